@@ -0,0 +1,107 @@
+package easycontainers
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// sqlDumpExtensions are the dump file extensions the mysql and postgres entrypoints can
+// load on startup
+var sqlDumpExtensions = []string{".sql", ".sql.gz"}
+
+// dumpInitScript returns the name to copy the dump at dumpPath into the entrypoint's
+// startup script directory as. The name starts with order, so the dump can be loaded
+// before or after the other startup scripts.
+func dumpInitScript(dumpPath, order string) (string, error) {
+	for _, ext := range sqlDumpExtensions {
+		if strings.HasSuffix(strings.ToLower(dumpPath), ext) {
+			return order + "-easycontainers-dump" + ext, nil
+		}
+	}
+
+	return "", fmt.Errorf("dump %s has an unsupported extension, expected %s", dumpPath, strings.Join(sqlDumpExtensions, " or "))
+}
+
+// readDump reads the dump at dumpPath, relative to the GOPATH.
+func readDump(dumpPath string) ([]byte, error) {
+	return ioutil.ReadFile(path.Join(GoPath(), dumpPath))
+}
+
+// dumpFileName returns the name of a dump of the container written now, ending with suffix.
+func dumpFileName(containerName, suffix string) string {
+	return fmt.Sprintf("%s-%s%s", containerName, time.Now().Format("20060102-150405"), suffix)
+}
+
+// writeDump writes the dump to a new file in dir, creating dir if it doesn't exist, and
+// returns the path of the file.
+func writeDump(dir, name string, content []byte) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(dir, name)
+
+	err = ioutil.WriteFile(file, content, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return file, nil
+}
+
+// copyFileFromContainer reads the file at the path in the container.
+func copyFileFromContainer(ctx context.Context, client *client.Client, containerID, file string) ([]byte, error) {
+	reader, _, err := client.CopyFromContainer(ctx, containerID, file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s wasn't in the copy from the container", file)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+// dumpOnFailure writes a dump with dump when the callback failed and dir is set, so the
+// database can be inspected after the container is gone. Failing to write the dump is only
+// printed, so that the error from the callback is the one returned.
+func dumpOnFailure(callbackErr error, dir string, dump func(dir string) ([]string, error)) {
+	if callbackErr == nil || dir == "" {
+		return
+	}
+
+	files, err := dump(dir)
+	if err != nil {
+		fmt.Printf("failed to dump the database after the callback failed: %s\n", err)
+
+		return
+	}
+
+	for _, f := range files {
+		fmt.Printf("dumped the database to %s\n", f)
+	}
+}
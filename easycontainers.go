@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"context"
+	"encoding/binary"
 	"errors"
 	"math/rand"
 	"strings"
@@ -22,6 +23,7 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 )
 
@@ -105,6 +107,29 @@ func CleanupAllContainers() error {
 		}
 	}
 
+	// networks can only be removed once their containers are gone
+	if len(containers) > 0 {
+		err = WaitForCleanup()
+		if err != nil {
+			return err
+		}
+	}
+
+	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{
+		Filters: args,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, network := range networks {
+		fmt.Println("Removing Network", network.Name)
+
+		if err := cli.NetworkRemove(ctx, network.ID); err != nil {
+			return err
+		}
+	}
+
 	return err
 }
 
@@ -213,43 +238,223 @@ func Tar(src string, writers ...io.Writer) error {
 	})
 }
 
+// copyFileToContainer writes a single file with the content to dir in the container.
+// Unlike the temp files we Tar for the startup sql, the file can be given a specific
+// name and mode, which is needed for config files that are ignored if they are world
+// writable.
+func copyFileToContainer(ctx context.Context, client *client.Client, containerID, dir, name string, content []byte, mode int64) error {
+	tarContent := bytes.Buffer{}
+	tw := tar.NewWriter(&tarContent)
+
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    mode,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(content)
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.CopyToContainer(ctx, containerID, dir, &tarContent, types.CopyToContainerOptions{})
+}
+
 func dockerExec(ctx context.Context, client *client.Client, containerID string, cmd []string) error {
+	_, err := dockerExecOutput(ctx, client, containerID, cmd)
+
+	return err
+}
+
+// dockerExecOutput runs the command in the container and returns what it wrote to stdout.
+// If the command exits with a non-zero code, the error holds what it wrote to stderr, or
+// to stdout if it didn't write anything to stderr.
+func dockerExecOutput(ctx context.Context, client *client.Client, containerID string, cmd []string) (string, error) {
 	e, err := client.ContainerExecCreate(ctx, containerID, types.ExecConfig{
 		Detach:       true,
 		Tty:          false,
+		AttachStdout: true,
 		AttachStderr: true,
 		Cmd:          cmd,
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	attach, err := client.ContainerExecAttach(ctx, e.ID, types.ExecConfig{
+		AttachStdout: true,
 		AttachStderr: true,
 		Detach:       false,
 		Tty:          false,
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer attach.Close()
 
-	b := bytes.Buffer{}
-	_, err = io.Copy(&b, attach.Reader)
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+
+	err = demux(attach.Reader, &stdout, &stderr)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	inspect, err := client.ContainerExecInspect(ctx, e.ID)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if inspect.ExitCode != 0 {
-		return errors.New(b.String())
+		if stderr.Len() == 0 {
+			return "", errors.New(stdout.String())
+		}
+
+		return "", errors.New(stderr.String())
 	}
 
-	return nil
+	return stdout.String(), nil
+}
+
+// containerLogs returns everything the container has written to stdout and stderr.
+func containerLogs(ctx context.Context, client *client.Client, containerID string) (string, error) {
+	reader, err := client.ContainerLogs(ctx, containerID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	b := bytes.Buffer{}
+
+	err = demux(reader, &b, &b)
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// demux splits the stream docker returns for the output of containers and execs
+// without a tty, where each chunk of output has an 8 byte header saying which stream it
+// was written to and how long it is.
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+
+	for {
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+
+		_, err = io.CopyN(w, r, size)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// containerDiedError is returned when a container stops running while we're waiting on it.
+type containerDiedError struct {
+	ExitCode int
+	Logs     string
+}
+
+func (e *containerDiedError) Error() string {
+	lines := strings.Split(strings.TrimSpace(e.Logs), "\n")
+	if len(lines) > 20 {
+		lines = lines[len(lines)-20:]
+	}
+
+	return fmt.Sprintf(
+		"the container abruptly stopped running with exit code %d, the last logs were:\n%s",
+		e.ExitCode,
+		strings.Join(lines, "\n"),
+	)
+}
+
+// waitForHealthy checks every second if the container's healthcheck is passing, and
+// exits when it is, when the container stops running, or when the timeout occurs --
+// whichever comes first
+func waitForHealthy(ctx context.Context, client *client.Client, containerID string, timeout time.Duration) error {
+	var (
+		interval  = time.NewTicker(1 * time.Second)
+		deadline  = time.NewTimer(timeout)
+		prevState = ""
+	)
+	defer interval.Stop()
+	defer deadline.Stop()
+
+	for {
+		select {
+		case <-deadline.C:
+			inspect, err := client.ContainerInspect(ctx, containerID)
+			if err != nil {
+				return err
+			}
+
+			numOfLogs := len(inspect.State.Health.Log)
+			lastHealthLog := ""
+
+			if numOfLogs > 0 {
+				lastHealthLog = inspect.State.Health.Log[numOfLogs-1].Output
+			}
+
+			return fmt.Errorf("timed out waiting for container to be healthy, the last healtcheck error was: %s", lastHealthLog)
+		case <-interval.C:
+		}
+
+		inspect, err := client.ContainerInspect(ctx, containerID)
+		if err != nil {
+			return err
+		}
+
+		// container died, quit healthchecking and bail
+		if !inspect.State.Running && !inspect.State.Restarting {
+			logs, err := containerLogs(ctx, client, containerID)
+			if err != nil {
+				return err
+			}
+
+			return &containerDiedError{
+				ExitCode: inspect.State.ExitCode,
+				Logs:     logs,
+			}
+		}
+
+		if prevState != inspect.State.Health.Status {
+			fmt.Println("STATUS CHANGE:", inspect.State.Health.Status)
+			prevState = inspect.State.Health.Status
+
+			if inspect.State.Health.Status == "healthy" {
+				for _, l := range inspect.State.Health.Log {
+					fmt.Println(l.Output)
+				}
+
+				return nil
+			}
+		}
+	}
 }
 
 func getFreePort() (int, error) {
@@ -284,6 +489,60 @@ func getFreePort() (int, error) {
 	return 0, errors.New("took too long to find free port")
 }
 
+// createNetwork creates a bridge network for the containers of a topology, like a primary
+// and its replicas, to reach each other on.
+func createNetwork(ctx context.Context, client *client.Client, name string) (string, error) {
+	resp, err := client.NetworkCreate(ctx, name, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.ID, nil
+}
+
+// networkingConfig connects a container to the network, where the other containers on it
+// can reach it by alias. If networkName is empty, the container uses the default network.
+func networkingConfig(networkName, alias string) *network.NetworkingConfig {
+	if networkName == "" {
+		return nil
+	}
+
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			networkName: {
+				Aliases: []string{alias},
+			},
+		},
+	}
+}
+
+// removeContainer stops and removes the container, for cleaning up after the containers
+// started alongside the main one, like replicas.
+func removeContainer(ctx context.Context, client *client.Client, containerID string) {
+	client.ContainerStop(ctx, containerID, durationPointer(30*time.Second))
+	client.ContainerRemove(ctx, containerID, types.ContainerRemoveOptions{
+		Force: true,
+	})
+}
+
+// tmpfsMounts returns tmpfs mounts for the directories, each limited to size if it isn't empty.
+func tmpfsMounts(size string, dirs ...string) map[string]string {
+	options := "rw"
+	if size != "" {
+		options += ",size=" + size
+	}
+
+	mounts := make(map[string]string, len(dirs))
+	for _, d := range dirs {
+		mounts[d] = options
+	}
+
+	return mounts
+}
+
 func durationPointer(d time.Duration) *time.Duration {
 	return &d
 }
@@ -0,0 +1,361 @@
+package easycontainers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// csvNull is the value a csv cell has to have to be loaded as NULL
+const csvNull = "NULL"
+
+// Fixture is a YAML, JSON or CSV file of rows to load into a table once the container
+// is ready.
+//
+// Path is a path to the file, relative to the GOPATH. The format is picked by the extension
+// (.yml, .yaml, .json or .csv). YAML and JSON files hold a list of objects, one per row, and
+// CSV files have a header row with the column names. A null value, or a CSV cell of NULL,
+// is inserted as NULL.
+//
+// Table is the table, optionally schema qualified, that the rows are inserted into. If it is
+// empty, the file name without the extension is used, so blog.authors.csv is loaded into
+// blog.authors.
+type Fixture struct {
+	Path  string
+	Table string
+}
+
+// table returns the table the fixture is loaded into.
+func (f *Fixture) table() string {
+	if f.Table != "" {
+		return f.Table
+	}
+
+	base := path.Base(f.Path)
+
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// rows reads the rows from the fixture file.
+func (f *Fixture) rows() ([]map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(path.Join(GoPath(), f.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []map[string]interface{}
+
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".yml", ".yaml":
+		var raw []map[string]interface{}
+
+		err = yaml.Unmarshal(b, &raw)
+		if err != nil {
+			return nil, err
+		}
+
+		rows = raw
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+
+		err = decoder.Decode(&rows)
+		if err != nil {
+			return nil, err
+		}
+	case ".csv":
+		rows, err = readCSV(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("fixture %s has an unsupported extension, expected .yml, .yaml, .json or .csv", f.Path)
+	}
+
+	for _, row := range rows {
+		for column, value := range row {
+			row[column], err = fixtureValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("fixture %s, column %s: %s", f.Path, column, err)
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+// readCSV reads the csv rows into maps keyed by the column names in the header row.
+func readCSV(r io.Reader) ([]map[string]interface{}, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	rows := make([]map[string]interface{}, 0, len(records)-1)
+
+	for _, record := range records[1:] {
+		row := make(map[string]interface{}, len(header))
+
+		for i, column := range header {
+			if record[i] == csvNull {
+				row[column] = nil
+			} else {
+				row[column] = record[i]
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// fixtureValue converts a value decoded from a fixture file into something the sql
+// drivers know how to insert. Nested objects and lists are inserted as json.
+func fixtureValue(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i, nil
+		}
+
+		return x.Float64()
+	case int:
+		return int64(x), nil
+	case uint64:
+		return int64(x), nil
+	case map[interface{}]interface{}, map[string]interface{}, []interface{}:
+		b, err := json.Marshal(jsonCompatible(x))
+		if err != nil {
+			return nil, err
+		}
+
+		return string(b), nil
+	default:
+		return v, nil
+	}
+}
+
+// jsonCompatible converts the map[interface{}]interface{} values yaml decodes objects
+// into, which encoding/json can't marshal, into map[string]interface{}.
+func jsonCompatible(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, val := range x {
+			m[fmt.Sprint(k)] = jsonCompatible(val)
+		}
+
+		return m
+	case map[string]interface{}:
+		for k, val := range x {
+			x[k] = jsonCompatible(val)
+		}
+
+		return x
+	case []interface{}:
+		for i, val := range x {
+			x[i] = jsonCompatible(val)
+		}
+
+		return x
+	default:
+		return v
+	}
+}
+
+// loadFixtures inserts the rows of every fixture in a single transaction, with foreign
+// key checks turned off so the fixtures can be loaded in any order. If truncate is true,
+// the fixture tables are emptied first. They are emptied with DELETE rather than TRUNCATE
+// so that everything stays in the one transaction.
+//
+// Some of the settings the fixtures change last for the whole session, so the fixtures are
+// loaded on a connection of their own, which is reset afterwards whatever the outcome, so
+// it goes back into the pool the way it came out.
+func loadFixtures(db *sql.DB, d sqlDialect, fixtures []Fixture, truncate bool) (err error) {
+	tables := make([]string, 0, len(fixtures))
+	for _, f := range fixtures {
+		tables = append(tables, d.quote(f.table()))
+	}
+
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	defer func() {
+		for _, stmt := range d.resetSession(tables) {
+			_, resetErr := conn.ExecContext(ctx, internalSQL(stmt))
+			if resetErr != nil && err == nil {
+				err = fmt.Errorf("resetting the session after loading the fixtures: %s", resetErr)
+			}
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = loadFixturesTx(tx, d, fixtures, tables, truncate)
+	if err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func loadFixturesTx(tx *sql.Tx, d sqlDialect, fixtures []Fixture, tables []string, truncate bool) error {
+	for _, stmt := range d.foreignKeyChecks(tables, false) {
+		_, err := tx.Exec(internalSQL(stmt))
+		if err != nil {
+			return err
+		}
+	}
+
+	if truncate {
+		// delete in reverse so that child tables listed after their parents are emptied first
+		for i := len(tables) - 1; i >= 0; i-- {
+			_, err := tx.Exec(internalSQL("DELETE FROM " + tables[i]))
+			if err != nil {
+				return fmt.Errorf("emptying %s: %s", fixtures[i].table(), err)
+			}
+		}
+	}
+
+	for i, f := range fixtures {
+		rows, err := f.rows()
+		if err != nil {
+			return err
+		}
+
+		identity, err := identityColumn(tx, d, tables[i])
+		if err != nil {
+			return err
+		}
+
+		// explicit values for the identity column are only allowed with identity insert
+		// on, and leaving the column out is only allowed with it off, so it follows the
+		// rows that set it
+		var identityInsert bool
+
+		for n, row := range rows {
+			if setsIdentity := hasColumn(row, identity); setsIdentity != identityInsert {
+				_, err = tx.Exec(internalSQL(d.identityInsert(tables[i], setsIdentity)))
+				if err != nil {
+					return err
+				}
+
+				identityInsert = setsIdentity
+			}
+
+			query, args := insertStatement(d, tables[i], row)
+
+			_, err = tx.Exec(internalSQL(query), args...)
+			if err != nil {
+				return fmt.Errorf("fixture %s, row %d: %s", f.Path, n+1, err)
+			}
+		}
+
+		if identityInsert {
+			_, err = tx.Exec(internalSQL(d.identityInsert(tables[i], false)))
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("loaded %d rows into %s\n", len(rows), f.table())
+	}
+
+	for _, stmt := range d.foreignKeyChecks(tables, true) {
+		_, err := tx.Exec(internalSQL(stmt))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// identityColumn returns the name of the table's identity column, or an empty string if
+// it doesn't have one or the database takes explicit values for it anyway.
+func identityColumn(tx *sql.Tx, d sqlDialect, table string) (string, error) {
+	if d.identityColumn == nil {
+		return "", nil
+	}
+
+	var name string
+
+	err := tx.QueryRow(internalSQL(d.identityColumn(table))).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return name, err
+}
+
+// hasColumn reports whether the row has a value, even a null one, for the column.
+// Column names are compared case insensitively, like the databases do by default.
+func hasColumn(row map[string]interface{}, column string) bool {
+	if column == "" {
+		return false
+	}
+
+	for c := range row {
+		if strings.EqualFold(c, column) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// insertStatement builds an INSERT for the row, with the columns in sorted order.
+func insertStatement(d sqlDialect, table string, row map[string]interface{}) (string, []interface{}) {
+	columns := make([]string, 0, len(row))
+	for c := range row {
+		columns = append(columns, c)
+	}
+
+	sort.Strings(columns)
+
+	var (
+		quoted       = make([]string, len(columns))
+		placeholders = make([]string, len(columns))
+		args         = make([]interface{}, len(columns))
+	)
+
+	for i, c := range columns {
+		quoted[i] = d.quote(c)
+		placeholders[i] = d.bindVar(i + 1)
+		args[i] = row[c]
+	}
+
+	return fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s)",
+		table,
+		strings.Join(quoted, ", "),
+		strings.Join(placeholders, ", "),
+	), args
+}
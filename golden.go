@@ -0,0 +1,233 @@
+package easycontainers
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// numericTypes are the database type names, across the databases, of the columns whose
+// values are written to golden files without quotes
+var numericTypes = map[string]bool{
+	"BIGINT":     true,
+	"BIT":        true,
+	"DECIMAL":    true,
+	"DOUBLE":     true,
+	"FLOAT":      true,
+	"FLOAT4":     true,
+	"FLOAT8":     true,
+	"INT":        true,
+	"INT2":       true,
+	"INT4":       true,
+	"INT8":       true,
+	"INTEGER":    true,
+	"MEDIUMINT":  true,
+	"MONEY":      true,
+	"NUMERIC":    true,
+	"REAL":       true,
+	"SMALLINT":   true,
+	"SMALLMONEY": true,
+	"TINYINT":    true,
+	"YEAR":       true,
+}
+
+// UpdateGolden makes AssertGolden write the golden files instead of checking them. It can
+// also be turned on by setting EASYCONTAINERS_UPDATE_GOLDEN=1. To set it with an -update
+// flag, register the flag in the test package and copy it over in TestMain:
+//
+//	var update = flag.Bool("update", false, "update the golden files")
+//
+//	func TestMain(m *testing.M) {
+//		flag.Parse()
+//		easycontainers.UpdateGolden = *update
+//		os.Exit(m.Run())
+//	}
+var UpdateGolden bool
+
+// updateGoldenEnv is the environment variable that turns on UpdateGolden
+const updateGoldenEnv = "EASYCONTAINERS_UPDATE_GOLDEN"
+
+// updateGolden returns whether the golden files should be written instead of checked.
+func updateGolden() bool {
+	if UpdateGolden {
+		return true
+	}
+
+	update, _ := strconv.ParseBool(os.Getenv(updateGoldenEnv))
+
+	return update
+}
+
+// assertGolden checks that the tables match the golden file at path, and reports a diff
+// to t if they don't. When UpdateGolden is set, the golden file is written instead.
+func assertGolden(t TestingT, db *sql.DB, d sqlDialect, path string, tables []string) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	b := bytes.Buffer{}
+
+	for i, table := range tables {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		err := writeGoldenTable(&b, db, d, table)
+		if err != nil {
+			t.Errorf("reading the rows of %s: %s", table, err)
+
+			return false
+		}
+	}
+
+	actual := b.String()
+
+	if updateGolden() {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(actual), 0644)
+		}
+
+		if err != nil {
+			t.Errorf("updating golden file %s: %s", path, err)
+
+			return false
+		}
+
+		return true
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		t.Errorf("golden file %s doesn't exist, set UpdateGolden or %s=1 to create it", path, updateGoldenEnv)
+
+		return false
+	}
+
+	if err != nil {
+		t.Errorf("reading golden file %s: %s", path, err)
+
+		return false
+	}
+
+	if string(expected) == actual {
+		return true
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(expected)),
+		B:        difflib.SplitLines(actual),
+		FromFile: path,
+		ToFile:   "database",
+		Context:  3,
+	})
+	if err != nil {
+		t.Errorf("diffing golden file %s: %s", path, err)
+
+		return false
+	}
+
+	t.Errorf("the tables don't match golden file %s, set UpdateGolden or %s=1 to update it\n%s", path, updateGoldenEnv, diff)
+
+	return false
+}
+
+// writeGoldenTable writes the table as a comment with its name, a header line with the
+// columns, and a line per row, with the rows sorted.
+func writeGoldenTable(b *bytes.Buffer, db *sql.DB, d sqlDialect, table string) error {
+	rows, err := db.Query(internalSQL("SELECT * FROM " + d.quote(table)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+
+	for rows.Next() {
+		var (
+			values   = make([]interface{}, len(columns))
+			pointers = make([]interface{}, len(columns))
+		)
+
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return err
+		}
+
+		formatted := make([]string, len(values))
+		for i, v := range values {
+			formatted[i] = goldenValue(v, types[i].DatabaseTypeName())
+		}
+
+		lines = append(lines, strings.Join(formatted, " | "))
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(lines)
+
+	fmt.Fprintf(b, "-- %s\n", table)
+	b.WriteString(strings.Join(columns, " | "))
+	b.WriteString("\n")
+
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	return nil
+}
+
+// goldenValue formats a value the same way whichever type the driver returned it as.
+// Strings and times are quoted, so NULL and the string "NULL" can be told apart, and
+// times are written in UTC.
+func goldenValue(v interface{}, databaseType string) string {
+	numeric := numericTypes[strings.TrimPrefix(strings.ToUpper(databaseType), "UNSIGNED ")]
+
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return goldenValue(string(x), databaseType)
+	case string:
+		if numeric {
+			return x
+		}
+
+		return strconv.Quote(x)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case time.Time:
+		return strconv.Quote(x.UTC().Format(time.RFC3339Nano))
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,246 @@
+package easycontainers
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change, read from a pair of files named
+// like 001_create_authors.up.sql and 001_create_authors.down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationError is returned when a migration fails to apply. Err is the error
+// returned by the database server.
+type MigrationError struct {
+	Version   int
+	Name      string
+	Direction string
+	Err       error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("migration %d_%s.%s.sql failed: %s", e.Version, e.Name, e.Direction, e.Err)
+}
+
+// ReadMigrations reads the migrations in dir, sorted by version. Files that don't
+// match the NNN_name.up.sql or NNN_name.down.sql naming are ignored.
+func ReadMigrations(dir string) ([]Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, f := range files {
+		match := migrationFile.FindStringSubmatch(f.Name())
+		if f.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{
+				Version: version,
+				Name:    match[2],
+			}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations %d_%s and %d_%s have the same version", version, m.Name, version, match[2])
+		}
+
+		b, err := ioutil.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// appliedMigrations returns the versions recorded in the migrations table,
+// creating the table first if this is the first time migrating.
+func appliedMigrations(db *sql.DB, d sqlDialect) (map[int]bool, error) {
+	_, err := db.Exec(internalSQL(d.createMigrationsTable))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(internalSQL(fmt.Sprintf("SELECT version FROM %s", d.migrationsTable)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+
+	for rows.Next() {
+		var version int
+
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// migrateUp applies, in order, every migration in dir that hasn't been applied yet.
+func migrateUp(db *sql.DB, d sqlDialect, dir string) error {
+	migrations, err := ReadMigrations(dir)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(db, d)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+
+		err = runMigration(
+			db,
+			d,
+			m.Up,
+			fmt.Sprintf("INSERT INTO %s (version, name) VALUES (%s, %s)", d.migrationsTable, d.bindVar(1), d.bindVar(2)),
+			m.Version,
+			m.Name,
+		)
+		if err != nil {
+			return &MigrationError{
+				Version:   m.Version,
+				Name:      m.Name,
+				Direction: "up",
+				Err:       err,
+			}
+		}
+
+		fmt.Printf("applied migration %d_%s\n", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+// migrateDown rolls back, newest first, every applied migration in dir with a
+// version greater than the specified version.
+func migrateDown(db *sql.DB, d sqlDialect, dir string, version int) error {
+	migrations, err := ReadMigrations(dir)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(db, d)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+
+		if m.Version <= version || !applied[m.Version] {
+			continue
+		}
+
+		err = runMigration(
+			db,
+			d,
+			m.Down,
+			fmt.Sprintf("DELETE FROM %s WHERE version = %s", d.migrationsTable, d.bindVar(1)),
+			m.Version,
+		)
+		if err != nil {
+			return &MigrationError{
+				Version:   m.Version,
+				Name:      m.Name,
+				Direction: "down",
+				Err:       err,
+			}
+		}
+
+		fmt.Printf("rolled back migration %d_%s\n", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+// runMigration runs the migration sql and the statement that records it in the
+// migrations table in the same transaction. If the database's scripts use batch
+// separators, the batches of the migration run one after the other.
+//
+// Databases that commit DDL implicitly, like mysql, keep the statements that ran before
+// a failing one, but the migration is only recorded once all of them have run, so a
+// partly applied migration is never recorded as applied.
+func runMigration(db *sql.DB, d sqlDialect, migration, record string, args ...interface{}) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	batches := []string{migration}
+	if d.batches != nil {
+		batches = d.batches(migration)
+	}
+
+	for _, batch := range batches {
+		if batch == "" {
+			continue
+		}
+
+		_, err = tx.Exec(batch)
+		if err != nil {
+			tx.Rollback()
+
+			return err
+		}
+	}
+
+	_, err = tx.Exec(internalSQL(record), args...)
+	if err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit()
+}
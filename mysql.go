@@ -1,6 +1,7 @@
 package easycontainers
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +14,8 @@ import (
 
 	"bytes"
 	"path"
+	"sort"
+	"strings"
 
 	"time"
 
@@ -22,18 +25,80 @@ import (
 	"github.com/docker/go-connections/nat"
 )
 
+// while the startup sql runs, the entrypoint runs a temporary server with networking
+// turned off, so being able to connect over tcp means the startup sql is finished
+// and the container is fully initialized
+var mysqlHealthcheck = &container.HealthConfig{
+	Test:     []string{"CMD-SHELL", "mysql -uroot -ppass -h127.0.0.1 --protocol=tcp --comments -e '" + internalSQL("SELECT 1") + "'"},
+	Interval: 5 * time.Second,
+	Timeout:  1 * time.Minute,
+}
+
 // MySQL is a container using the official mysql docker image.
 //
 // Path is a path to a sql file, relative to the GOPATH. If set, it will run the sql in
 // the file when initializing the container.
 //
 // Query is a string of SQL. If set, it will run the sql when initializing the container.
+//
+// Migrations is a path to a directory of migration files, relative to the GOPATH. If set,
+// the NNN_name.up.sql files in it are applied in order once the container is ready, and
+// the applied versions are recorded in mysql.schema_migrations. Each migration runs in a
+// transaction, but mysql commits DDL statements like CREATE TABLE as soon as they run, so
+// a migration that fails part of the way through leaves the statements before the failing
+// one applied. It isn't recorded as applied, so fix the schema or recreate the container
+// before running it again.
+//
+// Fixtures are files of rows loaded into tables once the container is ready, after the
+// migrations have been applied.
+//
+// Config is a map of server options, like sql_mode or max_allowed_packet, and ConfigFile
+// is a my.cnf fragment. Both are written to a config file the server reads on startup,
+// with Config under [mysqld] followed by ConfigFile. A ConfigFile without a section
+// header is also read as [mysqld] options. The effective values can be checked with
+// Variables.
+//
+// FastMode trades durability for speed, which is fine for data that only lives as long as
+// the test does. The data directory is mounted on a tmpfs, and the redo log and binary
+// log aren't flushed on every commit. Options in Config take precedence over the ones
+// FastMode sets. TmpfsSize limits the size of the tmpfs, like "512m" or "2g". If it is
+// empty, docker's default of half the host's memory is used.
+//
+// ReplicaPorts are the ports of the replicas to start alongside the container, which then
+// becomes the primary. Replication is GTID based, and the replicas are caught up with the
+// primary before the callback runs. AddReplicas adds replicas on free ports.
+//
+// QueryLog turns on the general query log, so the statements the server receives can be
+// checked with Queries.
+//
+// DumpPath is a path to a .sql or .sql.gz dump, like one written by mysqldump, relative
+// to the GOPATH, to seed the database from. It is loaded before the startup sql, so Path
+// and Query can be left empty or used to make changes on top of the dump.
+//
+// FailureDumpDir is a directory to write a dump of the database to if the callback
+// returns an error, so the database can be inspected after the container is gone, like in
+// CI. The dump can be loaded into another container with DumpPath.
 type MySQL struct {
-	Client        *client.Client
-	ContainerName string
-	Port          int
-	Path          string
-	Query         string
+	Client         *client.Client
+	ContainerName  string
+	Port           int
+	Path           string
+	Query          string
+	Migrations     string
+	Fixtures       []Fixture
+	Config         map[string]string
+	ConfigFile     string
+	FastMode       bool
+	TmpfsSize      string
+	ReplicaPorts   []int
+	QueryLog       bool
+	DumpPath       string
+	FailureDumpDir string
+
+	pool        sqlPool
+	containerID string
+	replicaIDs  []string
+	buildErr    error
 }
 
 // NewMySQL returns a new instance of MySQL and the port it will be using.
@@ -69,10 +134,22 @@ func NewMySQLWithPort(name string, port int) *MySQL {
 	}
 }
 
+// addErr keeps the first error from the builders for Container to return.
+func (m *MySQL) addErr(err error) {
+	if m.buildErr == nil {
+		m.buildErr = err
+	}
+}
+
 // Container spins up the mysql container and runs. When the method exits, the
 // container is stopped and removed.
 func (m *MySQL) Container(f func() error) error {
 	ctx := context.Background()
+
+	if m.buildErr != nil {
+		return m.buildErr
+	}
+
 	reader, err := m.Client.ImagePull(ctx, "docker.io/library/mysql:latest", types.ImagePullOptions{})
 	if err != nil {
 		return err
@@ -84,18 +161,24 @@ func (m *MySQL) Container(f func() error) error {
 		return err
 	}
 
-	removingContainer := make(chan struct{}, 1)
+	var networkName string
+
+	if len(m.ReplicaPorts) > 0 {
+		networkName = m.ContainerName
+
+		networkID, err := createNetwork(ctx, m.Client, networkName)
+		if err != nil {
+			return err
+		}
+		defer m.Client.NetworkRemove(ctx, networkID)
+	}
 
 	resp, err := m.Client.ContainerCreate(
 		ctx,
 		&container.Config{
-			Image: "mysql:latest",
-			Env:   []string{"MYSQL_ROOT_PASSWORD=pass"},
-			Healthcheck: &container.HealthConfig{
-				Test:     []string{"CMD-SHELL", "mysql -uroot -ppass -e 'SELECT \"startup SQL initialized\" FROM mysql.z_z_'"},
-				Interval: 5 * time.Second,
-				Timeout:  1 * time.Minute,
-			},
+			Image:       "mysql:latest",
+			Env:         m.env(),
+			Healthcheck: mysqlHealthcheck,
 		},
 		&container.HostConfig{
 			PortBindings: nat.PortMap{
@@ -106,84 +189,48 @@ func (m *MySQL) Container(f func() error) error {
 					},
 				},
 			},
+			Tmpfs:       m.tmpfs(),
+			NetworkMode: container.NetworkMode(networkName),
 		},
-		nil,
+		networkingConfig(networkName, mysqlPrimaryAlias),
 		m.ContainerName,
 	)
 	if err != nil {
 		return err
 	}
+	m.containerID = resp.ID
 	defer func() {
-		removingContainer <- struct{}{}
-
 		m.Client.ContainerStop(ctx, resp.ID, durationPointer(30*time.Second))
 		m.Client.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{
 			Force: true,
 		})
 	}()
+	// the pool is closed before the container is removed
+	defer m.pool.close()
 
-	err = m.Client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	if len(m.Config) > 0 || m.ConfigFile != "" || m.FastMode || m.QueryLog || len(m.ReplicaPorts) > 0 {
+		// mysql ignores config files that are world writable
+		err = copyFileToContainer(ctx, m.Client, resp.ID, "/etc/mysql/conf.d", "easycontainers.cnf", []byte(m.config(m.replicationConfig(0))), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	script, err := readStartupSQL(m.Path, m.Query)
 	if err != nil {
 		return err
 	}
 
-	waitUntilHealthy := make(chan struct{})
-	containerDied := make(chan struct{})
-
-	go func() {
-		prevState := ""
-		interval := time.NewTicker(1 * time.Second)
-
-		for range interval.C {
-			select {
-			case <-removingContainer:
-				return
-			default:
-			}
-
-			inspect, err := m.Client.ContainerInspect(ctx, resp.ID)
-			if err != nil {
-				panic(err)
-			}
-
-			// container died, quit healthchecking and bail
-			if !inspect.State.Running && !inspect.State.Restarting {
-				containerDied <- struct{}{}
-
-				return
-			}
-
-			if prevState != inspect.State.Health.Status {
-				fmt.Println("STATUS CHANGE:", inspect.State.Health.Status)
-				prevState = inspect.State.Health.Status
-
-				if inspect.State.Health.Status == "healthy" {
-					for _, l := range inspect.State.Health.Log {
-						fmt.Println(l.Output)
-					}
-
-					waitUntilHealthy <- struct{}{}
-				}
-			}
-		}
-	}()
-
-	var sql string
+	if m.DumpPath != "" {
+		script.dumpPath = m.DumpPath
 
-	if m.Path != "" {
-		b, err := ioutil.ReadFile(path.Join(GoPath(), m.Path))
+		script.dumpFile, err = m.copyDump(ctx)
 		if err != nil {
 			return err
 		}
-
-		sql = string(b)
 	}
 
-	if m.Query != "" {
-		// the semicolon is in case the sql variable wasn't empty and the
-		// previous sql string didn't end with a semicolon
-		sql += "; " + m.Query
-	}
+	sql := script.String()
 
 	if sql != "" {
 		file, err := ioutil.TempFile(os.TempDir(), prefix+"*.sql")
@@ -198,16 +245,15 @@ func (m *MySQL) Container(f func() error) error {
 			return err
 		}
 
-		// we create the table mysql.z_z_(id integer) after all the other sql has been run
-		// so that we can query the table to see if all the startup sql is finished running,
-		// which means that the container if fully initialized
-		_, err = io.Copy(file, bytes.NewBufferString(sql+";CREATE TABLE mysql.z_z_(id integer);"))
+		_, err = io.Copy(file, bytes.NewBufferString(sql))
 		if err != nil {
 			return err
 		}
 
 		file.Close()
 
+		script.file = path.Base(file.Name())
+
 		tarContent := bytes.Buffer{}
 
 		err = Tar(file.Name(), &tarContent)
@@ -215,40 +261,385 @@ func (m *MySQL) Container(f func() error) error {
 			return err
 		}
 
+		// the sql is copied in before the container starts, otherwise the entrypoint
+		// could look for startup scripts before the file is there
 		err = m.Client.CopyToContainer(ctx, resp.ID, "/docker-entrypoint-initdb.d/", &tarContent, types.CopyToContainerOptions{})
 		if err != nil {
 			return err
 		}
+	}
+
+	err = m.Client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return err
+	}
+
+	err = waitForHealthy(ctx, m.Client, resp.ID, 1*time.Minute)
+	if err != nil {
+		// if the startup sql fails, the entrypoint exits and the container stops, so
+		// look for the reason in the logs
+		return script.failed(err, mysqlDialect.startupError)
+	}
+
+	err = waitForDB(mysqlDialect, m.DSN())
+	if err != nil {
+		return err
+	}
+
+	if len(m.ReplicaPorts) > 0 {
+		defer m.removeReplicas(ctx)
+
+		err = m.startReplicas(ctx, networkName)
+		if err != nil {
+			return err
+		}
+	}
 
-		timeout := time.NewTimer(1 * time.Minute)
-
-		select {
-		case <-containerDied:
-			return errors.New("the container abruptly stopped running")
-		case <-waitUntilHealthy:
-			// for some reason, even after it seems healthy, it seems to need a few extra seconds
-			// to actually be usable from the code, otherwise we get the error
-			// [mysql] packets.go:36: unexpected EOF
-			wait := time.NewTimer(3 * time.Second)
-			<-wait.C
-		case <-timeout.C:
-			inspect, err := m.Client.ContainerInspect(ctx, resp.ID)
-			if err != nil {
-				panic(err)
-			}
-
-			numOfLogs := len(inspect.State.Health.Log)
-			lastHealthLog := ""
-
-			if numOfLogs > 0 {
-				lastHealthLog = inspect.State.Health.Log[numOfLogs-1].Output
-			}
-
-			return fmt.Errorf("timed out waiting for container to be healthy, the last healtcheck error was: %s", lastHealthLog)
+	if m.Migrations != "" {
+		err = m.MigrateUp()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(m.Fixtures) > 0 {
+		err = m.loadFixtures(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(m.ReplicaPorts) > 0 {
+		err = m.WaitForReplicas()
+		if err != nil {
+			return err
 		}
 	}
 
 	fmt.Println("successfully created mysql container")
 
-	return f()
+	err = f()
+	dumpOnFailure(err, m.FailureDumpDir, m.Dump)
+
+	return err
+}
+
+// DSN returns the data source name for connecting to the container from the host.
+func (m *MySQL) DSN() string {
+	return fmt.Sprintf("root:pass@tcp(localhost:%d)/?parseTime=true&multiStatements=true", m.Port)
+}
+
+// MigrateUp applies the migrations in Migrations that haven't been applied yet. It is
+// called automatically when the container starts, but can be called again after
+// MigrateDown to test rolling forward.
+func (m *MySQL) MigrateUp() error {
+	db, err := m.DB()
+	if err != nil {
+		return err
+	}
+
+	return migrateUp(db, mysqlDialect, path.Join(GoPath(), m.Migrations))
+}
+
+// MigrateDown rolls back the applied migrations in Migrations with a version greater
+// than the specified version, newest first. MigrateDown(0) rolls back all of them.
+func (m *MySQL) MigrateDown(version int) error {
+	db, err := m.DB()
+	if err != nil {
+		return err
+	}
+
+	return migrateDown(db, mysqlDialect, path.Join(GoPath(), m.Migrations), version)
+}
+
+// AddFixtures adds the specified Fixtures to be loaded when the container starts.
+//
+// Fixtures are loaded in the order they are added, after the migrations have been
+// applied.
+func (m *MySQL) AddFixtures(f ...Fixture) *MySQL {
+	m.Fixtures = append(m.Fixtures, f...)
+
+	return m
+}
+
+// ReloadFixtures empties the fixture tables and loads the fixtures again, so each test
+// can start from the same data.
+func (m *MySQL) ReloadFixtures() error {
+	return m.loadFixtures(true)
+}
+
+func (m *MySQL) loadFixtures(truncate bool) error {
+	db, err := m.DB()
+	if err != nil {
+		return err
+	}
+
+	return loadFixtures(db, mysqlDialect, m.Fixtures, truncate)
+}
+
+// tmpfs returns the tmpfs mounts for the container, which is just the data directory
+// in FastMode.
+func (m *MySQL) tmpfs() map[string]string {
+	if !m.FastMode {
+		return nil
+	}
+
+	return tmpfsMounts(m.TmpfsSize, "/var/lib/mysql")
+}
+
+// config returns the contents of the config file built from Config and ConfigFile. The
+// replication options are added on top of them.
+func (m *MySQL) config(replication map[string]string) string {
+	config := map[string]string{}
+
+	if m.FastMode {
+		config["innodb_flush_log_at_trx_commit"] = "0"
+		config["innodb_doublewrite"] = "0"
+		config["sync_binlog"] = "0"
+	}
+
+	if m.QueryLog {
+		config["general_log"] = "ON"
+		config["log_output"] = "TABLE"
+	}
+
+	for k, v := range m.Config {
+		config[k] = v
+	}
+
+	for k, v := range replication {
+		config[k] = v
+	}
+
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	b := bytes.NewBufferString("[mysqld]\n")
+
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s=%s\n", k, config[k])
+	}
+
+	b.WriteString(m.ConfigFile)
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// Variables returns the values of the global server variables with the specified names,
+// so tests can check the effective server configuration.
+func (m *MySQL) Variables(names ...string) (map[string]string, error) {
+	if len(names) == 0 {
+		return map[string]string{}, nil
+	}
+
+	db, err := m.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	placeholders := make([]string, len(names))
+	args := make([]interface{}, len(names))
+
+	for i, n := range names {
+		placeholders[i] = "?"
+		args[i] = n
+	}
+
+	rows, err := db.Query(
+		internalSQL(fmt.Sprintf("SHOW GLOBAL VARIABLES WHERE Variable_name IN (%s)", strings.Join(placeholders, ", "))),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variables := make(map[string]string, len(names))
+
+	for rows.Next() {
+		var name, value string
+
+		err = rows.Scan(&name, &value)
+		if err != nil {
+			return nil, err
+		}
+
+		variables[name] = value
+	}
+
+	return variables, rows.Err()
+}
+
+// Queries returns the statements the server received between from and to, in the order it
+// received them. A zero from or to leaves that end of the window open. QueryLog has to be
+// set. The statements easycontainers sends itself, like the healthchecks and the queries
+// of Count, AssertRows and AssertGolden, are left out.
+func (m *MySQL) Queries(from, to time.Time) ([]QueryLogEntry, error) {
+	if !m.QueryLog {
+		return nil, errors.New("the query log is off, set QueryLog to capture queries")
+	}
+
+	db, err := m.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	// the query reading the log is logged too, and is left out like the package's other
+	// statements
+	rows, err := db.Query(internalSQL(`
+		SELECT event_time, argument
+		FROM mysql.general_log
+		WHERE command_type IN ('Query', 'Execute')
+	`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []QueryLogEntry
+
+	for rows.Next() {
+		var e QueryLogEntry
+
+		err = rows.Scan(&e.Time, &e.Statement)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return filterQueryLog(entries, from, to), nil
+}
+
+// DB returns a connection pool to the container, opened the first time it is needed. The
+// pool is closed when the container is removed, so it shouldn't be closed by the caller.
+func (m *MySQL) DB() (*sql.DB, error) {
+	return m.pool.get(mysqlDialect, m.DSN())
+}
+
+// Exec runs a statement against the container.
+func (m *MySQL) Exec(query string, args ...interface{}) (sql.Result, error) {
+	db, err := m.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	return db.Exec(query, args...)
+}
+
+// QueryRows runs a query against the container and returns the rows as maps of column
+// name to value.
+func (m *MySQL) QueryRows(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	db, err := m.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	return queryRows(db, query, args...)
+}
+
+// Count returns the number of rows in the table matching where, like "author_id = ?".
+// If where is empty, all of the rows are counted.
+func (m *MySQL) Count(table, where string, args ...interface{}) (int, error) {
+	db, err := m.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	return countRows(db, mysqlDialect, table, where, args...)
+}
+
+// AssertRows checks that the rows in the table are the expected ones, ignoring their
+// order, and reports a failure to t if they aren't. Only the columns in the expected rows
+// are compared.
+func (m *MySQL) AssertRows(t TestingT, table string, expected []map[string]interface{}) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	db, err := m.DB()
+	if err != nil {
+		t.Errorf("connecting to mysql: %s", err)
+
+		return false
+	}
+
+	return assertRows(t, db, mysqlDialect, table, expected)
+}
+
+// AssertGolden checks that the tables match the golden file at path, relative to the
+// working directory, and reports a diff to t if they don't. When UpdateGolden is set, the
+// golden file is written instead.
+//
+// The tables are written in the order they are specified, each with its columns and its
+// rows sorted, so the file only changes when the data does.
+func (m *MySQL) AssertGolden(t TestingT, path string, tables ...string) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	db, err := m.DB()
+	if err != nil {
+		t.Errorf("connecting to mysql: %s", err)
+
+		return false
+	}
+
+	return assertGolden(t, db, mysqlDialect, path, tables)
+}
+
+// mysqlDumpCommand dumps the databases that aren't the server's own, along with the
+// migrations table if there is one
+const mysqlDumpCommand = `set -e
+databases=$(mysql -uroot -ppass -N -e "SELECT schema_name FROM information_schema.schemata WHERE schema_name NOT IN ('mysql', 'information_schema', 'performance_schema', 'sys')")
+if [ -n "$databases" ]; then
+	mysqldump -uroot -ppass --set-gtid-purged=OFF --routines --events --triggers --databases $databases
+fi
+if [ -n "$(mysql -uroot -ppass -N -e "SHOW TABLES FROM mysql LIKE 'schema_migrations'")" ]; then
+	echo 'USE mysql;'
+	mysqldump -uroot -ppass --set-gtid-purged=OFF mysql schema_migrations
+fi`
+
+// Dump writes a dump of the databases, made with mysqldump, to a new file in dir and
+// returns the path of the file. The server's own databases aren't dumped, apart from the
+// migrations table.
+func (m *MySQL) Dump(dir string) ([]string, error) {
+	dump, err := dockerExecOutput(context.Background(), m.Client, m.containerID, []string{"sh", "-c", mysqlDumpCommand})
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := writeDump(dir, dumpFileName(m.ContainerName, ".sql"), []byte(dump))
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{file}, nil
+}
+
+// copyDump copies DumpPath into the startup script directory, where the entrypoint loads
+// it before the startup sql. It returns the name of the copy.
+func (m *MySQL) copyDump(ctx context.Context) (string, error) {
+	name, err := dumpInitScript(m.DumpPath, "00")
+	if err != nil {
+		return "", err
+	}
+
+	content, err := readDump(m.DumpPath)
+	if err != nil {
+		return "", err
+	}
+
+	return name, copyFileToContainer(ctx, m.Client, m.containerID, "/docker-entrypoint-initdb.d", name, content, 0644)
 }
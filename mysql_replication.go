@@ -0,0 +1,318 @@
+package easycontainers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+// mysqlPrimaryAlias is the host name the replicas reach the primary on
+const mysqlPrimaryAlias = "primary"
+
+// AddReplicas adds n replicas, each using a free port. If n isn't positive, or the free
+// ports can't be found, Container returns the error.
+func (m *MySQL) AddReplicas(n int) *MySQL {
+	if n <= 0 {
+		m.addErr(fmt.Errorf("AddReplicas needs a positive number of replicas, got %d", n))
+
+		return m
+	}
+
+	for i := 0; i < n; i++ {
+		port, err := getFreePort()
+		if err != nil {
+			m.addErr(err)
+
+			return m
+		}
+
+		m.ReplicaPorts = append(m.ReplicaPorts, port)
+	}
+
+	return m
+}
+
+// ReplicaDSNs returns the data source names for connecting to the replicas from the host,
+// in the same order as ReplicaPorts.
+func (m *MySQL) ReplicaDSNs() []string {
+	dsns := make([]string, len(m.ReplicaPorts))
+
+	for i, port := range m.ReplicaPorts {
+		dsns[i] = fmt.Sprintf("root:pass@tcp(localhost:%d)/?parseTime=true&multiStatements=true", port)
+	}
+
+	return dsns
+}
+
+// WaitForReplicas waits until every replica has applied everything committed on the primary
+// so far. It times out if replication is paused.
+func (m *MySQL) WaitForReplicas() error {
+	primary, err := m.DB()
+	if err != nil {
+		return err
+	}
+
+	var executed string
+
+	err = primary.QueryRow(internalSQL("SELECT @@GLOBAL.gtid_executed")).Scan(&executed)
+	if err != nil {
+		return err
+	}
+
+	for i, dsn := range m.ReplicaDSNs() {
+		err = waitForGTIDs(dsn, executed)
+		if err != nil {
+			return fmt.Errorf("replica %d: %s", i, err)
+		}
+	}
+
+	return nil
+}
+
+// ReplicationLag returns how far behind the primary the replica with the specified index
+// is. It is zero when the replica has applied everything committed on the primary, and
+// otherwise the time since the last transaction it applied was committed on the primary.
+func (m *MySQL) ReplicationLag(replica int) (time.Duration, error) {
+	primary, err := m.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	db, err := m.openReplica(replica)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var executed string
+
+	err = primary.QueryRow(internalSQL("SELECT @@GLOBAL.gtid_executed")).Scan(&executed)
+	if err != nil {
+		return 0, err
+	}
+
+	var caughtUp bool
+
+	err = db.QueryRow(internalSQL("SELECT GTID_SUBSET(?, @@GLOBAL.gtid_executed)"), executed).Scan(&caughtUp)
+	if err != nil {
+		return 0, err
+	}
+
+	if caughtUp {
+		return 0, nil
+	}
+
+	var lag sql.NullInt64
+
+	err = db.QueryRow(internalSQL(`
+		SELECT TIMESTAMPDIFF(MICROSECOND, MAX(LAST_APPLIED_TRANSACTION_ORIGINAL_COMMIT_TIMESTAMP), NOW(6))
+		FROM performance_schema.replication_applier_status_by_worker
+	`)).Scan(&lag)
+	if err != nil {
+		return 0, err
+	}
+
+	if !lag.Valid {
+		return 0, fmt.Errorf("replica %d hasn't applied any transactions yet", replica)
+	}
+
+	return time.Duration(lag.Int64) * time.Microsecond, nil
+}
+
+// PauseReplication stops the replica with the specified index from applying changes from
+// the primary. The changes are still received, and are applied once ResumeReplication
+// is called.
+func (m *MySQL) PauseReplication(replica int) error {
+	return m.execReplica(replica, "STOP REPLICA SQL_THREAD")
+}
+
+// ResumeReplication resumes applying changes on a replica paused with PauseReplication.
+func (m *MySQL) ResumeReplication(replica int) error {
+	return m.execReplica(replica, "START REPLICA SQL_THREAD")
+}
+
+func (m *MySQL) execReplica(replica int, query string) error {
+	db, err := m.openReplica(replica)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(internalSQL(query))
+
+	return err
+}
+
+func (m *MySQL) openReplica(replica int) (*sql.DB, error) {
+	if replica < 0 || replica >= len(m.ReplicaPorts) {
+		return nil, fmt.Errorf("there is no replica %d, there are %d replicas", replica, len(m.ReplicaPorts))
+	}
+
+	return openDB(mysqlDialect.driver, m.ReplicaDSNs()[replica], 1*time.Minute)
+}
+
+// env returns the environment variables for the primary and the replicas.
+func (m *MySQL) env() []string {
+	env := []string{"MYSQL_ROOT_PASSWORD=pass"}
+
+	if len(m.ReplicaPorts) > 0 {
+		// the time zone tables are loaded with binary logging on, so the replicas would
+		// fail applying them on top of the ones they loaded themselves
+		env = append(env, "MYSQL_INITDB_SKIP_TZINFO=1")
+	}
+
+	return env
+}
+
+// replicationConfig returns the server options for the server with the specified id, the
+// primary being 0, or nil if there are no replicas.
+func (m *MySQL) replicationConfig(serverID int) map[string]string {
+	if len(m.ReplicaPorts) == 0 {
+		return nil
+	}
+
+	config := map[string]string{
+		"server_id":                strconv.Itoa(serverID + 1),
+		"log_bin":                  "mysql-bin",
+		"gtid_mode":                "ON",
+		"enforce_gtid_consistency": "ON",
+	}
+
+	if serverID > 0 {
+		config["read_only"] = "ON"
+	}
+
+	return config
+}
+
+// startReplicas starts the replicas on the network, and points them at the primary once
+// they are ready.
+func (m *MySQL) startReplicas(ctx context.Context, networkName string) error {
+	for i, port := range m.ReplicaPorts {
+		resp, err := m.Client.ContainerCreate(
+			ctx,
+			&container.Config{
+				Image:       "mysql:latest",
+				Env:         m.env(),
+				Healthcheck: mysqlHealthcheck,
+			},
+			&container.HostConfig{
+				PortBindings: nat.PortMap{
+					"3306/tcp": []nat.PortBinding{
+						{
+							HostIP:   "0.0.0.0",
+							HostPort: strconv.Itoa(port),
+						},
+					},
+				},
+				Tmpfs:       m.tmpfs(),
+				NetworkMode: container.NetworkMode(networkName),
+			},
+			networkingConfig(networkName, fmt.Sprintf("replica-%d", i)),
+			fmt.Sprintf("%s-replica-%d", m.ContainerName, i),
+		)
+		if err != nil {
+			return err
+		}
+
+		m.replicaIDs = append(m.replicaIDs, resp.ID)
+
+		err = copyFileToContainer(ctx, m.Client, resp.ID, "/etc/mysql/conf.d", "easycontainers.cnf", []byte(m.config(m.replicationConfig(i+1))), 0644)
+		if err != nil {
+			return err
+		}
+
+		err = m.Client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, id := range m.replicaIDs {
+		err := waitForHealthy(ctx, m.Client, id, 1*time.Minute)
+		if err != nil {
+			return fmt.Errorf("replica %d: %s", i, err)
+		}
+
+		db, err := m.openReplica(i)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(internalSQL(fmt.Sprintf(
+			"CHANGE REPLICATION SOURCE TO SOURCE_HOST='%s', SOURCE_USER='root', SOURCE_PASSWORD='pass', SOURCE_AUTO_POSITION=1, GET_SOURCE_PUBLIC_KEY=1",
+			mysqlPrimaryAlias,
+		)))
+		if err == nil {
+			_, err = db.Exec(internalSQL("START REPLICA"))
+		}
+
+		db.Close()
+
+		if err != nil {
+			return fmt.Errorf("replica %d: %s", i, err)
+		}
+
+		fmt.Printf("started mysql replica %d on port %d\n", i, m.ReplicaPorts[i])
+	}
+
+	return nil
+}
+
+// removeReplicas stops and removes the replica containers.
+func (m *MySQL) removeReplicas(ctx context.Context) {
+	for _, id := range m.replicaIDs {
+		removeContainer(ctx, m.Client, id)
+	}
+
+	m.replicaIDs = nil
+}
+
+// waitForGTIDs waits for the server to apply the transactions in the gtid set. If the
+// replica stops applying changes because of an error, the error is returned instead
+// of waiting for the timeout.
+func waitForGTIDs(dsn, gtids string) error {
+	db, err := openDB(mysqlDialect.driver, dsn, 1*time.Minute)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	deadline := time.Now().Add(1 * time.Minute)
+
+	for time.Now().Before(deadline) {
+		var timedOut int
+
+		err = db.QueryRow(internalSQL("SELECT WAIT_FOR_EXECUTED_GTID_SET(?, 1)"), gtids).Scan(&timedOut)
+		if err != nil {
+			return err
+		}
+
+		if timedOut == 0 {
+			return nil
+		}
+
+		var message string
+
+		err = db.QueryRow(internalSQL(`
+			SELECT LAST_ERROR_MESSAGE FROM performance_schema.replication_applier_status_by_worker
+			WHERE LAST_ERROR_NUMBER <> 0
+			LIMIT 1
+		`)).Scan(&message)
+		if err == nil {
+			return fmt.Errorf("replication failed: %s", message)
+		}
+
+		if err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	return fmt.Errorf("timed out waiting for the replica to catch up with the primary")
+}
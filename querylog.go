@@ -0,0 +1,168 @@
+package easycontainers
+
+import (
+	"bufio"
+	"encoding/xml"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlServerQueryLogSession is the name of the Extended Events session capturing the
+// statements when QueryLog is set
+const sqlServerQueryLogSession = "easycontainers_queries"
+
+// postgresLogLinePrefix is the log_line_prefix used when QueryLog is set, so the statements
+// in the logs have timestamps. They are logged as Unix epochs with milliseconds, because
+// the zone abbreviations %m uses depend on log_timezone and can't be parsed reliably.
+const postgresLogLinePrefix = "%n [%p] "
+
+// postgresLogLine matches the lines postgresLogLinePrefix starts, capturing the seconds and
+// milliseconds of the timestamp, the type of message and the rest of the line
+var postgresLogLine = regexp.MustCompile(`^(\d+)\.(\d{3}) \[\d+\] (\w+):\s+(.*)$`)
+
+// postgresStatement matches the messages log_statement=all logs statements with, for
+// statements sent as simple queries and as prepared statements respectively
+var postgresStatement = regexp.MustCompile(`^(?:statement|execute [^:]*): (.*)$`)
+
+// queryLogMarker starts the statements the package sends itself, like the healthchecks and
+// the statements the helpers build, so Queries leaves them out
+const queryLogMarker = "/* easycontainers */"
+
+// internalSQL marks the statement as one the package sends itself.
+func internalSQL(query string) string {
+	return queryLogMarker + " " + query
+}
+
+// QueryLogEntry is a statement the server received while the query log was on. Time is
+// when the server logged it.
+type QueryLogEntry struct {
+	Time      time.Time
+	Statement string
+}
+
+// filterQueryLog returns the entries logged between from and to, inclusive, in the order
+// they were logged, leaving out the package's own statements. A zero from or to leaves that
+// end of the window open.
+func filterQueryLog(entries []QueryLogEntry, from, to time.Time) []QueryLogEntry {
+	filtered := []QueryLogEntry{}
+
+	for _, e := range entries {
+		// parameterized statements can be logged wrapped in a call, like sp_executesql, so
+		// the marker isn't always at the start
+		if strings.Contains(e.Statement, queryLogMarker) {
+			continue
+		}
+
+		if !from.IsZero() && e.Time.Before(from) {
+			continue
+		}
+
+		if !to.IsZero() && e.Time.After(to) {
+			continue
+		}
+
+		filtered = append(filtered, e)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].Time.Before(filtered[j].Time)
+	})
+
+	return filtered
+}
+
+// parsePostgresQueryLog reads the statements out of the postgres logs. Statements that
+// span multiple lines are logged as is, so the lines after a statement that don't start
+// with the prefix are part of it.
+func parsePostgresQueryLog(logs string) []QueryLogEntry {
+	var (
+		entries []QueryLogEntry
+		current *QueryLogEntry
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(logs))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		match := postgresLogLine.FindStringSubmatch(line)
+		if match == nil {
+			if current != nil {
+				current.Statement += "\n" + line
+			}
+
+			continue
+		}
+
+		current = nil
+
+		if match[3] != "LOG" {
+			continue
+		}
+
+		statement := postgresStatement.FindStringSubmatch(match[4])
+		if statement == nil {
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		milliseconds, _ := strconv.ParseInt(match[2], 10, 64)
+
+		entries = append(entries, QueryLogEntry{
+			Time:      time.Unix(seconds, milliseconds*int64(time.Millisecond)),
+			Statement: statement[1],
+		})
+		current = &entries[len(entries)-1]
+	}
+
+	return entries
+}
+
+// xeRingBuffer is the part of an Extended Events ring buffer target's data we read.
+type xeRingBuffer struct {
+	Events []struct {
+		Name      string    `xml:"name,attr"`
+		Timestamp time.Time `xml:"timestamp,attr"`
+		Data      []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value"`
+		} `xml:"data"`
+	} `xml:"event"`
+}
+
+// parseSQLServerQueryLog reads the statements out of the ring buffer of the query log
+// session.
+func parseSQLServerQueryLog(targetData string) ([]QueryLogEntry, error) {
+	var buffer xeRingBuffer
+
+	err := xml.Unmarshal([]byte(targetData), &buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []QueryLogEntry{}
+
+	for _, e := range buffer.Events {
+		for _, d := range e.Data {
+			// sql_batch_completed has the sql in batch_text, and rpc_completed in statement
+			if d.Name != "batch_text" && d.Name != "statement" {
+				continue
+			}
+
+			entries = append(entries, QueryLogEntry{
+				Time:      e.Timestamp,
+				Statement: d.Value,
+			})
+		}
+	}
+
+	return entries, nil
+}
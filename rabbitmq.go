@@ -2,9 +2,10 @@ package easycontainers
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
-	"strconv"
+	"math/rand"
+	"strings"
 	"time"
 
 	"io"
@@ -13,26 +14,68 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
-	"github.com/docker/go-connections/nat"
 )
 
+// rabbitmqHealthcheck passes once the management API answers, which is shared by every
+// node of a cluster
+var rabbitmqHealthcheck = &container.HealthConfig{
+	Test:     []string{"CMD-SHELL", "until $(rabbitmqadmin -q list queues); do echo 'waiting for RabbitMQ container to be up'; sleep 1; done"},
+	Interval: 5 * time.Second,
+	Timeout:  1 * time.Minute,
+}
+
 const (
 	rabbitmqadmin = "rabbitmqadmin"
 
-	ExchangeTypeDirect = "direct"
-	ExchangeTypeFanout = "fanout"
+	ExchangeTypeDirect  = "direct"
+	ExchangeTypeFanout  = "fanout"
+	ExchangeTypeTopic   = "topic"
+	ExchangeTypeHeaders = "headers"
+
+	QueueTypeClassic = "classic"
+	QueueTypeQuorum  = "quorum"
+	QueueTypeStream  = "stream"
 )
 
 // RabbitMQ is a container using the official RabbitMQ:management docker image, which already
 // has rabbitmqadmin installed on startup.
+//
+// NodePorts are the ports of the nodes to start alongside the container, which all join a
+// cluster with it before anything is created, and NodeManagementPorts are the ports of
+// their management UIs, in the same order. AddNodes adds nodes on free ports.
+//
+// ManagementPort is the port the management UI and HTTP API are published on, which the
+// guest user can log into.
+//
+// DefinitionsPath is a path to a definitions file, relative to the GOPATH, like the one
+// exported from the management UI. It is imported before anything added with the builders
+// is created, so the builders can add to the topology it defines.
+//
+// Plugins are bundled plugins to enable, like PluginShovel, and PluginFiles are paths to .ez
+// plugin files, relative to the GOPATH, to install and enable. The plugins are enabled
+// before the broker starts, and are checked to be running before anything is created.
 type RabbitMQ struct {
-	Client        *client.Client
-	ContainerName string
-	Port          int
-	Vhosts        []Vhost
-	Exchanges     []Exchange
-	Queues        []Queue
-	Bindings      []QueueBinding
+	Client              *client.Client
+	ContainerName       string
+	Port                int
+	ManagementPort      int
+	DefinitionsPath     string
+	Plugins             []string
+	PluginFiles         []string
+	NodePorts           []int
+	NodeManagementPorts []int
+	Vhosts              []Vhost
+	Users               []User
+	Permissions         []Permission
+	Exchanges           []Exchange
+	Queues              []Queue
+	Bindings            []QueueBinding
+	ExchangeBindings    []ExchangeBinding
+
+	nodeIDs []string
+
+	// buildErr is the first error from the builders, which Container returns
+	buildErr error
 }
 
 // Vhost is a RabbitMQ Virtual Host
@@ -40,26 +83,84 @@ type Vhost struct {
 	Name string
 }
 
-// Exchange is a RabbitMQ Exchange
+// User is a RabbitMQ User. Tags are the user's tags, like "administrator" or "monitoring",
+// which decide what the user can do in the management UI and HTTP API. A user without tags
+// can't use them at all.
+type User struct {
+	Name     string
+	Password string
+	Tags     []string
+}
+
+// Permission is a User's permissions in a Vhost. Configure, Write and Read are regexes
+// matching the names of the resources the user can configure, write to and read from, and
+// an empty regex matches nothing. If Vhost is nil, the permissions are for the default
+// vhost.
+type Permission struct {
+	User      User
+	Vhost     *Vhost
+	Configure string
+	Write     string
+	Read      string
+}
+
+// Exchange is a RabbitMQ Exchange. Exchanges are durable unless Transient is set, and
+// AutoDelete deletes the exchange once the last binding from it is removed. Arguments are
+// the exchange's optional arguments, like "alternate-exchange".
 type Exchange struct {
-	Name  string
-	Type  string
-	Vhost *Vhost
+	Name       string
+	Type       string
+	Vhost      *Vhost
+	Transient  bool
+	AutoDelete bool
+	Arguments  map[string]interface{}
 }
 
-// Queue is a RabbitMQ Queue
+// Queue is a RabbitMQ Queue. AutoDelete deletes the queue once its last consumer
+// unsubscribes.
+//
+// Type is QueueTypeClassic, QueueTypeQuorum or QueueTypeStream, and the server's default,
+// which is usually classic, if it is empty. Quorum queues and streams are always declared
+// durable.
+//
+// MessageTTL, MaxLength, DeadLetterExchange, DeadLetterRoutingKey and Lazy set the
+// x-message-ttl, x-max-length, x-dead-letter-exchange, x-dead-letter-routing-key and
+// x-queue-mode arguments, and are left out when they are zero. Arguments holds any other
+// arguments, like "x-max-length-bytes" or "x-overflow", and takes precedence over the
+// fields.
 type Queue struct {
-	Name    string
-	Durable bool
-	Vhost   *Vhost
+	Name                 string
+	Durable              bool
+	Vhost                *Vhost
+	AutoDelete           bool
+	Type                 string
+	MessageTTL           time.Duration
+	MaxLength            int
+	DeadLetterExchange   string
+	DeadLetterRoutingKey string
+	Lazy                 bool
+	Arguments            map[string]interface{}
 }
 
-// QueueBinding is a RabbitMQ Binding between an Exchange (source) and Queue (destination)
+// QueueBinding is a RabbitMQ Binding between an Exchange (source) and Queue (destination).
+// Arguments are the binding's arguments, which is how headers exchanges match messages,
+// like {"x-match": "all", "format": "pdf"}.
 type QueueBinding struct {
 	Source      Exchange
 	Destination Queue
 	RoutingKey  string
 	Vhost       *Vhost
+	Arguments   map[string]interface{}
+}
+
+// ExchangeBinding is a RabbitMQ Binding between two Exchanges, which routes the messages
+// the source exchange matches to the destination exchange.
+type ExchangeBinding struct {
+	Source      Exchange
+	Destination Exchange
+	RoutingKey  string
+	Vhost       *Vhost
+	Arguments   map[string]interface{}
 }
 
 // NewRabbitMQ returns a new instance of RabbitMQ and the port it will be using.
@@ -69,29 +170,42 @@ func NewRabbitMQ(name string) (r *RabbitMQ, port int) {
 		panic(err)
 	}
 
+	managementPort, err := getFreePort()
+	if err != nil {
+		panic(err)
+	}
+
 	c, err := client.NewEnvClient()
 	if err != nil {
 		panic(err)
 	}
 
 	return &RabbitMQ{
-		ContainerName: prefix + "rabbit-" + name,
-		Port:          port,
-		Client:        c,
+		ContainerName:  prefix + "rabbit-" + name,
+		Port:           port,
+		ManagementPort: managementPort,
+		Client:         c,
 	}, port
 }
 
-// NewRabbitMQWithPort returns a new instance of RabbitMQ using the specified port.
+// NewRabbitMQWithPort returns a new instance of RabbitMQ using the specified port. The
+// management port is still picked automatically.
 func NewRabbitMQWithPort(name string, port int) *RabbitMQ {
+	managementPort, err := getFreePort()
+	if err != nil {
+		panic(err)
+	}
+
 	c, err := client.NewEnvClient()
 	if err != nil {
 		panic(err)
 	}
 
 	return &RabbitMQ{
-		ContainerName: prefix + "rabbit-" + name,
-		Port:          port,
-		Client:        c,
+		ContainerName:  prefix + "rabbit-" + name,
+		Port:           port,
+		ManagementPort: managementPort,
+		Client:         c,
 	}
 }
 
@@ -99,9 +213,27 @@ func NewRabbitMQWithPort(name string, port int) *RabbitMQ {
 // container is stopped and removed.
 //
 // The RabbitMQ components will be created in the following order:
-// Vhosts -> Exchanges -> Queues -> Bindings
+// Definitions -> Vhosts -> Users -> Permissions -> Exchanges -> Queues -> Bindings -> ExchangeBindings
+//
+// Everything added with the builders is loaded as one set of definitions, after the file at
+// DefinitionsPath. If that fails, the components are created one at a time through the
+// management API, and the error says which one failed.
 func (r *RabbitMQ) Container(f func() error) error {
 	ctx := context.Background()
+
+	if r.buildErr != nil {
+		return r.buildErr
+	}
+
+	if len(r.NodeManagementPorts) != len(r.NodePorts) {
+		return fmt.Errorf("every node needs a port and a management port, got %d NodePorts and %d NodeManagementPorts", len(r.NodePorts), len(r.NodeManagementPorts))
+	}
+
+	err := r.checkArguments()
+	if err != nil {
+		return err
+	}
+
 	reader, err := r.Client.ImagePull(ctx, "docker.io/library/rabbitmq:management-alpine", types.ImagePullOptions{})
 	if err != nil {
 		return err
@@ -113,138 +245,94 @@ func (r *RabbitMQ) Container(f func() error) error {
 		return err
 	}
 
-	removingContainer := make(chan struct{}, 1)
+	var networkName, hostname string
+
+	if len(r.NodePorts) > 0 {
+		networkName = r.ContainerName
+		hostname = rabbitmqNodeHostname(0)
+
+		networkID, err := createNetwork(ctx, r.Client, networkName)
+		if err != nil {
+			return err
+		}
+		defer r.Client.NetworkRemove(ctx, networkID)
+	}
 
 	resp, err := r.Client.ContainerCreate(
 		ctx,
 		&container.Config{
-			Image: "rabbitmq:management-alpine",
-			Healthcheck: &container.HealthConfig{
-				Test:     []string{"CMD-SHELL", "until $(rabbitmqadmin -q list queues); do echo 'waiting for RabbitMQ container to be up'; sleep 1; done"},
-				Interval: 5 * time.Second,
-				Timeout:  1 * time.Minute,
-			},
+			Image:       "rabbitmq:management-alpine",
+			Hostname:    hostname,
+			Healthcheck: rabbitmqHealthcheck,
 		},
 		&container.HostConfig{
-			PortBindings: nat.PortMap{
-				"5672/tcp": []nat.PortBinding{
-					{
-						HostIP:   "0.0.0.0",
-						HostPort: strconv.Itoa(r.Port),
-					},
-				},
-			},
+			PortBindings: rabbitmqPortBindings(r.Port, r.ManagementPort),
+			NetworkMode:  container.NetworkMode(networkName),
 		},
-		nil,
+		networkingConfig(networkName, hostname),
 		r.ContainerName,
 	)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		removingContainer <- struct{}{}
 
+	r.nodeIDs = []string{resp.ID}
+
+	defer func() {
 		r.Client.ContainerStop(ctx, resp.ID, durationPointer(30*time.Second))
 		r.Client.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{
 			Force: true,
 		})
 	}()
 
-	err = r.Client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	err = r.installPlugins(ctx, resp.ID)
 	if err != nil {
 		return err
 	}
 
-	waitUntilHealthy := make(chan struct{})
-	containerDied := make(chan struct{})
-
-	go func() {
-		prevState := ""
-		interval := time.NewTicker(1 * time.Second)
-
-		for range interval.C {
-			select {
-			case <-removingContainer:
-				return
-			default:
-			}
-
-			inspect, err := r.Client.ContainerInspect(ctx, resp.ID)
-			if err != nil {
-				panic(err)
-			}
+	cookie := fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64())
 
-			// container died, quit healthchecking and bail
-			if !inspect.State.Running && !inspect.State.Restarting {
-				containerDied <- struct{}{}
-
-				return
-			}
-
-			if prevState != inspect.State.Health.Status {
-				fmt.Println("STATUS CHANGE:", inspect.State.Health.Status)
-				prevState = inspect.State.Health.Status
-
-				if inspect.State.Health.Status == "healthy" {
-					for _, l := range inspect.State.Health.Log {
-						fmt.Println(l.Output)
-					}
-
-					waitUntilHealthy <- struct{}{}
-				}
-			}
-		}
-	}()
-
-	timeout := time.NewTimer(1 * time.Minute)
-
-	select {
-	case <-containerDied:
-		return errors.New("the container abruptly stopped running")
-	case <-waitUntilHealthy:
-		// do nothing
-	case <-timeout.C:
-		inspect, err := r.Client.ContainerInspect(ctx, resp.ID)
+	if len(r.NodePorts) > 0 {
+		err = copyErlangCookie(ctx, r.Client, resp.ID, cookie)
 		if err != nil {
-			panic(err)
+			return err
 		}
+	}
 
-		numOfLogs := len(inspect.State.Health.Log)
-		lastHealthLog := ""
-
-		if numOfLogs > 0 {
-			lastHealthLog = inspect.State.Health.Log[numOfLogs-1].Output
-		}
+	err = r.Client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return err
+	}
 
-		return fmt.Errorf("timed out waiting for container to be healthy, the last healtcheck error was: %s", lastHealthLog)
+	err = waitForHealthy(ctx, r.Client, resp.ID, 1*time.Minute)
+	if err != nil {
+		return err
 	}
 
-	for _, x := range r.Vhosts {
-		err = dockerExec(ctx, r.Client, resp.ID, x.CreateCommand())
-		if err != nil {
-			return err
-		}
+	err = r.checkPlugins(ctx, resp.ID)
+	if err != nil {
+		return err
 	}
 
-	for _, x := range r.Exchanges {
-		err = dockerExec(ctx, r.Client, resp.ID, x.CreateCommand())
+	if len(r.NodePorts) > 0 {
+		defer r.removeNodes(ctx)
+
+		err = r.startNodes(ctx, networkName, cookie)
 		if err != nil {
 			return err
 		}
 	}
 
-	for _, x := range r.Queues {
-		err = dockerExec(ctx, r.Client, resp.ID, x.CreateCommand())
+	if r.DefinitionsPath != "" {
+		err = r.loadDefinitions()
 		if err != nil {
 			return err
 		}
 	}
 
-	for _, x := range r.Bindings {
-		err = dockerExec(ctx, r.Client, resp.ID, x.CreateCommand())
-		if err != nil {
-			return err
-		}
+	err = r.provision()
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("successfully created rabbitmq container")
@@ -261,9 +349,27 @@ func (r *RabbitMQ) AddVhosts(v ...Vhost) *RabbitMQ {
 	return r
 }
 
+// AddUsers adds the specified Users to be created when the container starts.
+//
+// Users are created after the Vhosts.
+func (r *RabbitMQ) AddUsers(u ...User) *RabbitMQ {
+	r.Users = append(r.Users, u...)
+
+	return r
+}
+
+// AddPermissions adds the specified Permissions to be set when the container starts.
+//
+// Permissions are set after Vhosts and Users.
+func (r *RabbitMQ) AddPermissions(p ...Permission) *RabbitMQ {
+	r.Permissions = append(r.Permissions, p...)
+
+	return r
+}
+
 // AddExchanges adds the specified Exchanges to be created when the container starts.
 //
-// Exchanges are created after the Vhosts.
+// Exchanges are created after Vhosts, Users, and Permissions.
 func (r *RabbitMQ) AddExchanges(e ...Exchange) *RabbitMQ {
 	r.Exchanges = append(r.Exchanges, e...)
 
@@ -288,6 +394,21 @@ func (r *RabbitMQ) AddBinding(b ...QueueBinding) *RabbitMQ {
 	return r
 }
 
+// AddExchangeBinding adds the specified ExchangeBinding to be created when the container
+// starts.
+//
+// ExchangeBindings are created last.
+func (r *RabbitMQ) AddExchangeBinding(b ...ExchangeBinding) *RabbitMQ {
+	r.ExchangeBindings = append(r.ExchangeBindings, b...)
+
+	return r
+}
+
+// ManagementURL returns the URL of the management UI and HTTP API from the host.
+func (r *RabbitMQ) ManagementURL() string {
+	return fmt.Sprintf("http://localhost:%d", r.ManagementPort)
+}
+
 // CreateCommand returns a command for creating the Vhost from the command line.
 func (v *Vhost) CreateCommand() []string {
 	return []string{
@@ -298,6 +419,37 @@ func (v *Vhost) CreateCommand() []string {
 	}
 }
 
+// CreateCommand returns a command for creating the User from the command line.
+func (u *User) CreateCommand() []string {
+	return []string{
+		rabbitmqadmin,
+		"declare",
+		"user",
+		fmt.Sprintf("name=%s", u.Name),
+		fmt.Sprintf("password=%s", u.Password),
+		fmt.Sprintf("tags=%s", strings.Join(u.Tags, ",")),
+	}
+}
+
+// CreateCommand returns a command for setting the Permission from the command line.
+func (p *Permission) CreateCommand() []string {
+	vhost := "/"
+	if p.Vhost != nil {
+		vhost = p.Vhost.Name
+	}
+
+	return []string{
+		rabbitmqadmin,
+		"declare",
+		"permission",
+		fmt.Sprintf("vhost=%s", vhost),
+		fmt.Sprintf("user=%s", p.User.Name),
+		fmt.Sprintf("configure=%s", p.Configure),
+		fmt.Sprintf("write=%s", p.Write),
+		fmt.Sprintf("read=%s", p.Read),
+	}
+}
+
 // CreateCommand returns a command for creating the Exchange from the command line.
 func (e *Exchange) CreateCommand() []string {
 	args := []string{rabbitmqadmin}
@@ -316,8 +468,14 @@ func (e *Exchange) CreateCommand() []string {
 		"exchange",
 		fmt.Sprintf("name=%s", e.Name),
 		fmt.Sprintf("type=%s", e.Type),
+		fmt.Sprintf("durable=%t", !e.Transient),
+		fmt.Sprintf("auto_delete=%t", e.AutoDelete),
 	)
 
+	if len(e.Arguments) > 0 {
+		args = append(args, argumentsParameter(e.Arguments))
+	}
+
 	return args
 }
 
@@ -339,10 +497,15 @@ func (q *Queue) CreateCommand() []string {
 			"declare",
 			"queue",
 			fmt.Sprintf("name=%s", q.Name),
-			fmt.Sprintf("durable=%t", q.Durable),
+			fmt.Sprintf("durable=%t", q.durable()),
+			fmt.Sprintf("auto_delete=%t", q.AutoDelete),
 		}...,
 	)
 
+	if arguments := q.arguments(); len(arguments) > 0 {
+		args = append(args, argumentsParameter(arguments))
+	}
+
 	return args
 }
 
@@ -370,5 +533,139 @@ func (q *QueueBinding) CreateCommand() []string {
 		}...,
 	)
 
+	if len(q.Arguments) > 0 {
+		args = append(args, argumentsParameter(q.Arguments))
+	}
+
 	return args
 }
+
+// CreateCommand returns a command for creating the ExchangeBinding from the command line.
+func (b *ExchangeBinding) CreateCommand() []string {
+	args := []string{rabbitmqadmin}
+
+	if b.Vhost != nil {
+		args = append(
+			args,
+			"--vhost",
+			b.Vhost.Name,
+		)
+	}
+
+	args = append(
+		args,
+		[]string{
+			"declare",
+			"binding",
+			fmt.Sprintf("source=%s", b.Source.Name),
+			"destination_type=exchange",
+			fmt.Sprintf("destination=%s", b.Destination.Name),
+			fmt.Sprintf("routing_key=%s", b.RoutingKey),
+		}...,
+	)
+
+	if len(b.Arguments) > 0 {
+		args = append(args, argumentsParameter(b.Arguments))
+	}
+
+	return args
+}
+
+// durable returns whether the queue is declared durable, which quorum queues and streams
+// always are.
+func (q *Queue) durable() bool {
+	return q.Durable || q.Type == QueueTypeQuorum || q.Type == QueueTypeStream
+}
+
+// arguments returns the queue's arguments, from its fields and Arguments.
+func (q *Queue) arguments() map[string]interface{} {
+	arguments := map[string]interface{}{}
+
+	if q.Type != "" {
+		arguments["x-queue-type"] = q.Type
+	}
+
+	if q.MessageTTL > 0 {
+		arguments["x-message-ttl"] = int64(q.MessageTTL / time.Millisecond)
+	}
+
+	if q.MaxLength > 0 {
+		arguments["x-max-length"] = q.MaxLength
+	}
+
+	if q.DeadLetterExchange != "" {
+		arguments["x-dead-letter-exchange"] = q.DeadLetterExchange
+	}
+
+	if q.DeadLetterRoutingKey != "" {
+		arguments["x-dead-letter-routing-key"] = q.DeadLetterRoutingKey
+	}
+
+	if q.Lazy {
+		arguments["x-queue-mode"] = "lazy"
+	}
+
+	for k, v := range q.Arguments {
+		arguments[k] = v
+	}
+
+	return arguments
+}
+
+// addErr keeps the first error from the builders for Container to return.
+func (r *RabbitMQ) addErr(err error) {
+	if r.buildErr == nil {
+		r.buildErr = err
+	}
+}
+
+// checkArguments checks that the arguments of everything to be created can be passed to
+// rabbitmqadmin as JSON, so that CreateCommand doesn't have to return an error.
+func (r *RabbitMQ) checkArguments() error {
+	check := func(kind, name string, arguments map[string]interface{}) error {
+		_, err := json.Marshal(arguments)
+		if err != nil {
+			return fmt.Errorf("the arguments of %s %s can't be marshalled to JSON: %s", kind, name, err)
+		}
+
+		return nil
+	}
+
+	for _, x := range r.Exchanges {
+		err := check("exchange", x.Name, x.Arguments)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, x := range r.Queues {
+		err := check("queue", x.Name, x.arguments())
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, x := range r.Bindings {
+		err := check("the binding from", x.Source.Name, x.Arguments)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, x := range r.ExchangeBindings {
+		err := check("the binding from", x.Source.Name, x.Arguments)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// argumentsParameter returns the rabbitmqadmin parameter for the arguments, which
+// checkArguments has made sure can be marshalled.
+func argumentsParameter(arguments map[string]interface{}) string {
+	b, _ := json.Marshal(arguments)
+
+	return "arguments=" + string(b)
+}
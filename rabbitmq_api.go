@@ -0,0 +1,107 @@
+package easycontainers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// rabbitmqAPIClient is the client for the management HTTP API. Importing large definitions
+// can take a while, so the timeout is generous.
+var rabbitmqAPIClient = &http.Client{
+	Timeout: 2 * time.Minute,
+}
+
+// ExportDefinitions returns the definitions of the running broker, in the same format as
+// the file DefinitionsPath points to, so they can be saved at the end of a test and loaded
+// by another one.
+func (r *RabbitMQ) ExportDefinitions() ([]byte, error) {
+	var definitions json.RawMessage
+
+	err := r.managementAPI("GET", "/api/definitions", nil, &definitions)
+	if err != nil {
+		return nil, err
+	}
+
+	b := bytes.Buffer{}
+
+	err = json.Indent(&b, definitions, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	b.WriteString("\n")
+
+	return b.Bytes(), nil
+}
+
+// loadDefinitions imports the definitions file at DefinitionsPath, relative to the GOPATH.
+func (r *RabbitMQ) loadDefinitions() error {
+	b, err := ioutil.ReadFile(path.Join(GoPath(), r.DefinitionsPath))
+	if err != nil {
+		return err
+	}
+
+	err = r.managementAPI("POST", "/api/definitions", json.RawMessage(b), nil)
+	if err != nil {
+		return fmt.Errorf("loading definitions from %s: %s", r.DefinitionsPath, err)
+	}
+
+	return nil
+}
+
+// managementAPI sends a request to the management HTTP API as the guest user. If body isn't
+// nil, it is sent as JSON, and if out isn't nil, the response is decoded into it.
+func (r *RabbitMQ) managementAPI(method, apiPath string, body, out interface{}) error {
+	var reader io.Reader
+
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, r.ManagementURL()+apiPath, reader)
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth("guest", "guest")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := rabbitmqAPIClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		// errors have a reason, which says what was wrong with the request
+		var apiErr struct {
+			Reason string `json:"reason"`
+		}
+
+		if json.Unmarshal(b, &apiErr) == nil && apiErr.Reason != "" {
+			return fmt.Errorf("%s %s returned %s: %s", method, apiPath, resp.Status, apiErr.Reason)
+		}
+
+		return fmt.Errorf("%s %s returned %s: %s", method, apiPath, resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,243 @@
+package easycontainers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+// rabbitmqCookiePath is where the nodes read the Erlang cookie from, which has to be the
+// same on every node of a cluster. The entrypoint makes the directory owned by rabbitmq,
+// and the image sets HOME to it, so rabbitmqctl reads the same cookie.
+const rabbitmqCookiePath = "/var/lib/rabbitmq/.erlang.cookie"
+
+// AddNodes adds n nodes to the cluster, each using a free port and management port. If n
+// isn't positive, or the free ports can't be found, Container returns the error.
+func (r *RabbitMQ) AddNodes(n int) *RabbitMQ {
+	if n <= 0 {
+		r.addErr(fmt.Errorf("AddNodes needs a positive number of nodes, got %d", n))
+
+		return r
+	}
+
+	for i := 0; i < n; i++ {
+		port, err := getFreePort()
+		if err != nil {
+			r.addErr(err)
+
+			return r
+		}
+
+		managementPort, err := getFreePort()
+		if err != nil {
+			r.addErr(err)
+
+			return r
+		}
+
+		r.NodePorts = append(r.NodePorts, port)
+		r.NodeManagementPorts = append(r.NodeManagementPorts, managementPort)
+	}
+
+	return r
+}
+
+// NodeAddrs returns the addresses for connecting to every node of the cluster from the host,
+// starting with the container's own.
+func (r *RabbitMQ) NodeAddrs() []string {
+	addrs := []string{fmt.Sprintf("localhost:%d", r.Port)}
+
+	for _, port := range r.NodePorts {
+		addrs = append(addrs, fmt.Sprintf("localhost:%d", port))
+	}
+
+	return addrs
+}
+
+// StopNode stops the node with the specified index, like it failed, where node 0 is the
+// container and node i is the one on NodePorts[i-1]. The management API helpers use node 0,
+// so they don't work while it is stopped.
+func (r *RabbitMQ) StopNode(node int) error {
+	id, err := r.nodeID(node)
+	if err != nil {
+		return err
+	}
+
+	return r.Client.ContainerStop(context.Background(), id, durationPointer(30*time.Second))
+}
+
+// StartNode starts the node with the specified index after StopNode, and waits until it has
+// rejoined the cluster.
+func (r *RabbitMQ) StartNode(node int) error {
+	id, err := r.nodeID(node)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	err = r.Client.ContainerStart(ctx, id, types.ContainerStartOptions{})
+	if err != nil {
+		return err
+	}
+
+	return r.waitForNode(ctx, node)
+}
+
+// RestartNode restarts the node with the specified index, and waits until it has rejoined
+// the cluster.
+func (r *RabbitMQ) RestartNode(node int) error {
+	id, err := r.nodeID(node)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	err = r.Client.ContainerRestart(ctx, id, durationPointer(30*time.Second))
+	if err != nil {
+		return err
+	}
+
+	return r.waitForNode(ctx, node)
+}
+
+// startNodes starts the nodes on the network with the same Erlang cookie as the container,
+// and joins them to its cluster once they are ready.
+func (r *RabbitMQ) startNodes(ctx context.Context, networkName, cookie string) error {
+	for i, port := range r.NodePorts {
+		hostname := rabbitmqNodeHostname(i + 1)
+
+		resp, err := r.Client.ContainerCreate(
+			ctx,
+			&container.Config{
+				Image:       "rabbitmq:management-alpine",
+				Hostname:    hostname,
+				Healthcheck: rabbitmqHealthcheck,
+			},
+			&container.HostConfig{
+				PortBindings: rabbitmqPortBindings(port, r.NodeManagementPorts[i]),
+				NetworkMode:  container.NetworkMode(networkName),
+			},
+			networkingConfig(networkName, hostname),
+			fmt.Sprintf("%s-node-%d", r.ContainerName, i+1),
+		)
+		if err != nil {
+			return err
+		}
+
+		r.nodeIDs = append(r.nodeIDs, resp.ID)
+
+		err = r.installPlugins(ctx, resp.ID)
+		if err != nil {
+			return err
+		}
+
+		err = copyErlangCookie(ctx, r.Client, resp.ID, cookie)
+		if err != nil {
+			return err
+		}
+
+		err = r.Client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range r.NodePorts {
+		node := i + 1
+
+		err := waitForHealthy(ctx, r.Client, r.nodeIDs[node], 1*time.Minute)
+		if err != nil {
+			return fmt.Errorf("node %d: %s", node, err)
+		}
+
+		for _, cmd := range [][]string{
+			{"rabbitmqctl", "stop_app"},
+			{"rabbitmqctl", "join_cluster", "rabbit@" + rabbitmqNodeHostname(0)},
+			{"rabbitmqctl", "start_app"},
+		} {
+			err = dockerExec(ctx, r.Client, r.nodeIDs[node], cmd)
+			if err != nil {
+				return fmt.Errorf("joining node %d to the cluster: %s", node, err)
+			}
+		}
+
+		fmt.Printf("joined rabbitmq node %d on port %d to the cluster\n", node, r.NodePorts[i])
+	}
+
+	err := dockerExec(ctx, r.Client, r.nodeIDs[0], []string{"rabbitmqctl", "await_online_nodes", strconv.Itoa(len(r.nodeIDs)), "--timeout", "60"})
+	if err != nil {
+		return fmt.Errorf("waiting for the cluster: %s", err)
+	}
+
+	return nil
+}
+
+// waitForNode waits until the node has booted, which a node that was stopped only finishes
+// once it has rejoined the cluster.
+func (r *RabbitMQ) waitForNode(ctx context.Context, node int) error {
+	err := waitForHealthy(ctx, r.Client, r.nodeIDs[node], 1*time.Minute)
+	if err != nil {
+		return fmt.Errorf("node %d: %s", node, err)
+	}
+
+	err = dockerExec(ctx, r.Client, r.nodeIDs[node], []string{"rabbitmqctl", "await_startup", "--timeout", "60"})
+	if err != nil {
+		return fmt.Errorf("node %d: %s", node, err)
+	}
+
+	return nil
+}
+
+// removeNodes stops and removes the nodes started alongside the container.
+func (r *RabbitMQ) removeNodes(ctx context.Context) {
+	for _, id := range r.nodeIDs[1:] {
+		removeContainer(ctx, r.Client, id)
+	}
+}
+
+func (r *RabbitMQ) nodeID(node int) (string, error) {
+	if node < 0 || node >= len(r.nodeIDs) {
+		return "", fmt.Errorf("there is no node %d, the cluster has %d nodes", node, len(r.nodeIDs))
+	}
+
+	return r.nodeIDs[node], nil
+}
+
+// rabbitmqNodeHostname returns the host name of the node, which is also the part of its
+// node name after the @.
+func rabbitmqNodeHostname(node int) string {
+	return fmt.Sprintf("rabbit-%d", node)
+}
+
+// rabbitmqPortBindings publishes the amqp and management ports of a node.
+func rabbitmqPortBindings(port, managementPort int) nat.PortMap {
+	return nat.PortMap{
+		"5672/tcp": []nat.PortBinding{
+			{
+				HostIP:   "0.0.0.0",
+				HostPort: strconv.Itoa(port),
+			},
+		},
+		"15672/tcp": []nat.PortBinding{
+			{
+				HostIP:   "0.0.0.0",
+				HostPort: strconv.Itoa(managementPort),
+			},
+		},
+	}
+}
+
+// copyErlangCookie copies the cookie into the container, readable only by its owner, or
+// Erlang refuses to use it.
+func copyErlangCookie(ctx context.Context, client *client.Client, containerID, cookie string) error {
+	return copyFileToContainer(ctx, client, containerID, path.Dir(rabbitmqCookiePath), path.Base(rabbitmqCookiePath), []byte(cookie), 0400)
+}
@@ -0,0 +1,315 @@
+package easycontainers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// rabbitmqDefinitions is the format of definitions files and of /api/definitions, with the
+// parts the builders add to.
+type rabbitmqDefinitions struct {
+	Vhosts      []rabbitmqVhostDefinition      `json:"vhosts"`
+	Users       []rabbitmqUserDefinition       `json:"users"`
+	Permissions []rabbitmqPermissionDefinition `json:"permissions"`
+	Exchanges   []rabbitmqExchangeDefinition   `json:"exchanges"`
+	Queues      []rabbitmqQueueDefinition      `json:"queues"`
+	Bindings    []rabbitmqBindingDefinition    `json:"bindings"`
+}
+
+type rabbitmqVhostDefinition struct {
+	Name string `json:"name"`
+}
+
+type rabbitmqUserDefinition struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Tags     string `json:"tags"`
+}
+
+type rabbitmqPermissionDefinition struct {
+	User      string `json:"user"`
+	Vhost     string `json:"vhost"`
+	Configure string `json:"configure"`
+	Write     string `json:"write"`
+	Read      string `json:"read"`
+}
+
+type rabbitmqExchangeDefinition struct {
+	Name       string                 `json:"name"`
+	Vhost      string                 `json:"vhost"`
+	Type       string                 `json:"type"`
+	Durable    bool                   `json:"durable"`
+	AutoDelete bool                   `json:"auto_delete"`
+	Internal   bool                   `json:"internal"`
+	Arguments  map[string]interface{} `json:"arguments"`
+}
+
+type rabbitmqQueueDefinition struct {
+	Name       string                 `json:"name"`
+	Vhost      string                 `json:"vhost"`
+	Durable    bool                   `json:"durable"`
+	AutoDelete bool                   `json:"auto_delete"`
+	Arguments  map[string]interface{} `json:"arguments"`
+}
+
+type rabbitmqBindingDefinition struct {
+	Source          string                 `json:"source"`
+	Vhost           string                 `json:"vhost"`
+	Destination     string                 `json:"destination"`
+	DestinationType string                 `json:"destination_type"`
+	RoutingKey      string                 `json:"routing_key"`
+	Arguments       map[string]interface{} `json:"arguments"`
+}
+
+// rabbitmqItem is something added with the builders, with the management API request
+// that creates it on its own.
+type rabbitmqItem struct {
+	description string
+	method      string
+	path        string
+	body        interface{}
+}
+
+// provision creates everything added with the builders by loading it as one set of
+// definitions. Loading definitions fails without saying which item was wrong though, so
+// if it fails, the items are created one at a time to find the one that fails. Creating an
+// item that already exists with the same settings is a no-op, so the ones the load already
+// created don't get in the way.
+func (r *RabbitMQ) provision() error {
+	definitions := r.definitions()
+
+	items := definitions.items()
+	if len(items) == 0 {
+		return nil
+	}
+
+	err := r.managementAPI("POST", "/api/definitions", definitions, nil)
+	if err == nil {
+		return nil
+	}
+
+	for _, item := range items {
+		itemErr := r.managementAPI(item.method, item.path, item.body, nil)
+		if itemErr != nil {
+			return fmt.Errorf("creating %s: %s", item.description, itemErr)
+		}
+	}
+
+	return fmt.Errorf("loading the definitions failed: %s; creating them one at a time didn't reproduce it", err)
+}
+
+// definitions returns the definitions of everything added with the builders.
+//
+// A vhost declared with rabbitmqadmin or the management API gives the guest user that
+// declared it permissions on it, but one loaded from definitions doesn't, so the guest user
+// is given them unless it has permissions of its own.
+func (r *RabbitMQ) definitions() rabbitmqDefinitions {
+	d := rabbitmqDefinitions{
+		Vhosts:      []rabbitmqVhostDefinition{},
+		Users:       []rabbitmqUserDefinition{},
+		Permissions: []rabbitmqPermissionDefinition{},
+		Exchanges:   []rabbitmqExchangeDefinition{},
+		Queues:      []rabbitmqQueueDefinition{},
+		Bindings:    []rabbitmqBindingDefinition{},
+	}
+
+	guestPermissions := map[string]bool{}
+
+	for _, p := range r.Permissions {
+		if p.User.Name == "guest" {
+			guestPermissions[vhostName(p.Vhost)] = true
+		}
+	}
+
+	for _, v := range r.Vhosts {
+		d.Vhosts = append(d.Vhosts, rabbitmqVhostDefinition{
+			Name: v.Name,
+		})
+
+		if !guestPermissions[v.Name] {
+			d.Permissions = append(d.Permissions, rabbitmqPermissionDefinition{
+				User:      "guest",
+				Vhost:     v.Name,
+				Configure: ".*",
+				Write:     ".*",
+				Read:      ".*",
+			})
+		}
+	}
+
+	for _, u := range r.Users {
+		d.Users = append(d.Users, rabbitmqUserDefinition{
+			Name:     u.Name,
+			Password: u.Password,
+			Tags:     strings.Join(u.Tags, ","),
+		})
+	}
+
+	for _, p := range r.Permissions {
+		d.Permissions = append(d.Permissions, rabbitmqPermissionDefinition{
+			User:      p.User.Name,
+			Vhost:     vhostName(p.Vhost),
+			Configure: p.Configure,
+			Write:     p.Write,
+			Read:      p.Read,
+		})
+	}
+
+	for _, e := range r.Exchanges {
+		d.Exchanges = append(d.Exchanges, rabbitmqExchangeDefinition{
+			Name:       e.Name,
+			Vhost:      vhostName(e.Vhost),
+			Type:       e.Type,
+			Durable:    !e.Transient,
+			AutoDelete: e.AutoDelete,
+			Arguments:  nonNilArguments(e.Arguments),
+		})
+	}
+
+	for _, q := range r.Queues {
+		d.Queues = append(d.Queues, rabbitmqQueueDefinition{
+			Name:       q.Name,
+			Vhost:      vhostName(q.Vhost),
+			Durable:    q.durable(),
+			AutoDelete: q.AutoDelete,
+			Arguments:  q.arguments(),
+		})
+	}
+
+	for _, b := range r.Bindings {
+		d.Bindings = append(d.Bindings, rabbitmqBindingDefinition{
+			Source:          b.Source.Name,
+			Vhost:           vhostName(b.Vhost),
+			Destination:     b.Destination.Name,
+			DestinationType: "queue",
+			RoutingKey:      b.RoutingKey,
+			Arguments:       nonNilArguments(b.Arguments),
+		})
+	}
+
+	for _, b := range r.ExchangeBindings {
+		d.Bindings = append(d.Bindings, rabbitmqBindingDefinition{
+			Source:          b.Source.Name,
+			Vhost:           vhostName(b.Vhost),
+			Destination:     b.Destination.Name,
+			DestinationType: "exchange",
+			RoutingKey:      b.RoutingKey,
+			Arguments:       nonNilArguments(b.Arguments),
+		})
+	}
+
+	return d
+}
+
+// items returns the definitions as separate items, in the order they are created in.
+func (d rabbitmqDefinitions) items() []rabbitmqItem {
+	var items []rabbitmqItem
+
+	for _, x := range d.Vhosts {
+		items = append(items, rabbitmqItem{
+			description: fmt.Sprintf("vhost %s", x.Name),
+			method:      "PUT",
+			path:        fmt.Sprintf("/api/vhosts/%s", url.PathEscape(x.Name)),
+			body:        map[string]interface{}{},
+		})
+	}
+
+	for _, x := range d.Users {
+		items = append(items, rabbitmqItem{
+			description: fmt.Sprintf("user %s", x.Name),
+			method:      "PUT",
+			path:        fmt.Sprintf("/api/users/%s", url.PathEscape(x.Name)),
+			body: map[string]interface{}{
+				"password": x.Password,
+				"tags":     x.Tags,
+			},
+		})
+	}
+
+	for _, x := range d.Permissions {
+		items = append(items, rabbitmqItem{
+			description: fmt.Sprintf("the permissions of user %s in vhost %s", x.User, x.Vhost),
+			method:      "PUT",
+			path:        fmt.Sprintf("/api/permissions/%s/%s", url.PathEscape(x.Vhost), url.PathEscape(x.User)),
+			body: map[string]interface{}{
+				"configure": x.Configure,
+				"write":     x.Write,
+				"read":      x.Read,
+			},
+		})
+	}
+
+	for _, x := range d.Exchanges {
+		items = append(items, rabbitmqItem{
+			description: fmt.Sprintf("exchange %s in vhost %s", x.Name, x.Vhost),
+			method:      "PUT",
+			path:        fmt.Sprintf("/api/exchanges/%s/%s", url.PathEscape(x.Vhost), url.PathEscape(x.Name)),
+			body: map[string]interface{}{
+				"type":        x.Type,
+				"durable":     x.Durable,
+				"auto_delete": x.AutoDelete,
+				"internal":    x.Internal,
+				"arguments":   x.Arguments,
+			},
+		})
+	}
+
+	for _, x := range d.Queues {
+		items = append(items, rabbitmqItem{
+			description: fmt.Sprintf("queue %s in vhost %s", x.Name, x.Vhost),
+			method:      "PUT",
+			path:        fmt.Sprintf("/api/queues/%s/%s", url.PathEscape(x.Vhost), url.PathEscape(x.Name)),
+			body: map[string]interface{}{
+				"durable":     x.Durable,
+				"auto_delete": x.AutoDelete,
+				"arguments":   x.Arguments,
+			},
+		})
+	}
+
+	for _, x := range d.Bindings {
+		// the management API shortens the destination types in binding paths
+		destinationType := "q"
+		if x.DestinationType == "exchange" {
+			destinationType = "e"
+		}
+
+		items = append(items, rabbitmqItem{
+			description: fmt.Sprintf("the binding from exchange %s to %s %s in vhost %s", x.Source, x.DestinationType, x.Destination, x.Vhost),
+			method:      "POST",
+			path: fmt.Sprintf(
+				"/api/bindings/%s/e/%s/%s/%s",
+				url.PathEscape(x.Vhost),
+				url.PathEscape(x.Source),
+				destinationType,
+				url.PathEscape(x.Destination),
+			),
+			body: map[string]interface{}{
+				"routing_key": x.RoutingKey,
+				"arguments":   x.Arguments,
+			},
+		})
+	}
+
+	return items
+}
+
+// vhostName returns the name of the vhost, where nil is the default vhost.
+func vhostName(vhost *Vhost) string {
+	if vhost == nil {
+		return "/"
+	}
+
+	return vhost.Name
+}
+
+// nonNilArguments returns the arguments, or empty arguments if they are nil, since
+// definitions need arguments to be an object.
+func nonNilArguments(arguments map[string]interface{}) map[string]interface{} {
+	if arguments == nil {
+		return map[string]interface{}{}
+	}
+
+	return arguments
+}
@@ -0,0 +1,260 @@
+package easycontainers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// tapCount numbers the taps' queues, so that taps on the same exchange don't share one
+var tapCount int64
+
+// Message is a RabbitMQ message. Persistent sets the delivery mode to persistent, and
+// Expiration is the message's TTL, which isn't set if it is zero.
+//
+// Exchange, RoutingKey and Redelivered are only set on messages read from a queue, and are
+// where the message was published to and whether it was delivered before.
+type Message struct {
+	Body          []byte
+	Headers       map[string]interface{}
+	ContentType   string
+	CorrelationID string
+	MessageID     string
+	ReplyTo       string
+	Type          string
+	Persistent    bool
+	Priority      int
+	Expiration    time.Duration
+
+	Exchange    string
+	RoutingKey  string
+	Redelivered bool
+}
+
+// Tap is a temporary queue bound to an exchange, which gets a copy of every message the
+// exchange routes with the binding, so a test can check what the code under test published.
+type Tap struct {
+	Queue string
+
+	rabbit *RabbitMQ
+	vhost  *Vhost
+}
+
+// rabbitmqProperties are the properties of a message in the management API.
+type rabbitmqProperties struct {
+	Headers       map[string]interface{} `json:"headers,omitempty"`
+	ContentType   string                 `json:"content_type,omitempty"`
+	CorrelationID string                 `json:"correlation_id,omitempty"`
+	MessageID     string                 `json:"message_id,omitempty"`
+	ReplyTo       string                 `json:"reply_to,omitempty"`
+	Type          string                 `json:"type,omitempty"`
+	DeliveryMode  int                    `json:"delivery_mode,omitempty"`
+	Priority      int                    `json:"priority,omitempty"`
+	Expiration    string                 `json:"expiration,omitempty"`
+}
+
+// rabbitmqMessage is a message read from a queue with the management API.
+type rabbitmqMessage struct {
+	Payload     string             `json:"payload"`
+	Properties  rabbitmqProperties `json:"properties"`
+	Exchange    string             `json:"exchange"`
+	RoutingKey  string             `json:"routing_key"`
+	Redelivered bool               `json:"redelivered"`
+}
+
+// Publish publishes the message to the exchange in the vhost, or the default vhost if vhost
+// is nil, and returns whether the exchange routed it to any queue. The exchange can be empty
+// to publish to the default exchange, which routes to the queue named by the routing key.
+func (r *RabbitMQ) Publish(vhost *Vhost, exchange, routingKey string, m Message) (routed bool, err error) {
+	properties := rabbitmqProperties{
+		Headers:       m.Headers,
+		ContentType:   m.ContentType,
+		CorrelationID: m.CorrelationID,
+		MessageID:     m.MessageID,
+		ReplyTo:       m.ReplyTo,
+		Type:          m.Type,
+		Priority:      m.Priority,
+	}
+
+	if m.Persistent {
+		properties.DeliveryMode = 2
+	}
+
+	if m.Expiration > 0 {
+		properties.Expiration = strconv.FormatInt(int64(m.Expiration/time.Millisecond), 10)
+	}
+
+	// the management API calls the default exchange amq.default
+	if exchange == "" {
+		exchange = "amq.default"
+	}
+
+	var resp struct {
+		Routed bool `json:"routed"`
+	}
+
+	err = r.managementAPI(
+		"POST",
+		fmt.Sprintf("/api/exchanges/%s/%s/publish", vhostPath(vhost), url.PathEscape(exchange)),
+		map[string]interface{}{
+			"properties":       properties,
+			"routing_key":      routingKey,
+			"payload":          base64.StdEncoding.EncodeToString(m.Body),
+			"payload_encoding": "base64",
+		},
+		&resp,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Routed, nil
+}
+
+// GetMessages takes up to n messages from the queue in the vhost, or the default vhost if
+// vhost is nil, without waiting for more to arrive. The messages are removed from the queue.
+func (r *RabbitMQ) GetMessages(vhost *Vhost, queue string, n int) ([]Message, error) {
+	var got []rabbitmqMessage
+
+	err := r.managementAPI(
+		"POST",
+		fmt.Sprintf("/api/queues/%s/%s/get", vhostPath(vhost), url.PathEscape(queue)),
+		map[string]interface{}{
+			"count":    n,
+			"ackmode":  "ack_requeue_false",
+			"encoding": "base64",
+		},
+		&got,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, len(got))
+
+	for i, g := range got {
+		body, err := base64.StdEncoding.DecodeString(g.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		messages[i] = Message{
+			Body:          body,
+			Headers:       g.Properties.Headers,
+			ContentType:   g.Properties.ContentType,
+			CorrelationID: g.Properties.CorrelationID,
+			MessageID:     g.Properties.MessageID,
+			ReplyTo:       g.Properties.ReplyTo,
+			Type:          g.Properties.Type,
+			Persistent:    g.Properties.DeliveryMode == 2,
+			Priority:      g.Properties.Priority,
+			Exchange:      g.Exchange,
+			RoutingKey:    g.RoutingKey,
+			Redelivered:   g.Redelivered,
+		}
+
+		if g.Properties.Expiration != "" {
+			ms, err := strconv.ParseInt(g.Properties.Expiration, 10, 64)
+			if err == nil {
+				messages[i].Expiration = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+
+	return messages, nil
+}
+
+// ConsumeMessages takes n messages from the queue in the vhost, or the default vhost if vhost
+// is nil, waiting for them to arrive until the timeout passes. If fewer than n arrive, the
+// ones that did are returned along with an error.
+func (r *RabbitMQ) ConsumeMessages(vhost *Vhost, queue string, n int, timeout time.Duration) ([]Message, error) {
+	deadline := time.Now().Add(timeout)
+
+	var messages []Message
+
+	for {
+		got, err := r.GetMessages(vhost, queue, n-len(messages))
+		if err != nil {
+			return messages, err
+		}
+
+		messages = append(messages, got...)
+
+		if len(messages) >= n {
+			return messages, nil
+		}
+
+		if time.Now().After(deadline) {
+			return messages, fmt.Errorf("timed out waiting for messages from %s, got %d of %d", queue, len(messages), n)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Tap binds a new queue to the exchange in the vhost, or the default vhost if vhost is nil,
+// with the routing key, which is ignored by fanout exchanges and can be "#" to get every
+// message from a topic exchange. The default exchange can't be tapped. Close deletes the
+// queue.
+func (r *RabbitMQ) Tap(vhost *Vhost, exchange, routingKey string) (*Tap, error) {
+	t := &Tap{
+		Queue:  fmt.Sprintf("easycontainers.tap.%s.%d", exchange, atomic.AddInt64(&tapCount, 1)),
+		rabbit: r,
+		vhost:  vhost,
+	}
+
+	err := r.managementAPI(
+		"PUT",
+		fmt.Sprintf("/api/queues/%s/%s", vhostPath(vhost), url.PathEscape(t.Queue)),
+		map[string]interface{}{
+			"durable":     false,
+			"auto_delete": false,
+		},
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.managementAPI(
+		"POST",
+		fmt.Sprintf("/api/bindings/%s/e/%s/q/%s", vhostPath(vhost), url.PathEscape(exchange), url.PathEscape(t.Queue)),
+		map[string]interface{}{
+			"routing_key": routingKey,
+		},
+		nil,
+	)
+	if err != nil {
+		t.Close()
+
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Messages waits until the tap has captured n messages, until the timeout passes, and
+// returns them. If fewer than n are captured, the ones that were are returned along with
+// an error.
+func (t *Tap) Messages(n int, timeout time.Duration) ([]Message, error) {
+	return t.rabbit.ConsumeMessages(t.vhost, t.Queue, n, timeout)
+}
+
+// Close deletes the tap's queue, along with any messages still in it.
+func (t *Tap) Close() error {
+	return t.rabbit.managementAPI(
+		"DELETE",
+		fmt.Sprintf("/api/queues/%s/%s", vhostPath(t.vhost), url.PathEscape(t.Queue)),
+		nil,
+		nil,
+	)
+}
+
+// vhostPath returns the vhost as it appears in management API paths, where the default
+// vhost is an escaped slash.
+func vhostPath(vhost *Vhost) string {
+	return url.PathEscape(vhostName(vhost))
+}
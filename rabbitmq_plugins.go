@@ -0,0 +1,127 @@
+package easycontainers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	PluginShovel               = "rabbitmq_shovel"
+	PluginShovelManagement     = "rabbitmq_shovel_management"
+	PluginFederation           = "rabbitmq_federation"
+	PluginFederationManagement = "rabbitmq_federation_management"
+	PluginConsistentHash       = "rabbitmq_consistent_hash_exchange"
+
+	// PluginDelayedMessage isn't bundled with the image, so its .ez file has to be added to
+	// PluginFiles
+	PluginDelayedMessage = "rabbitmq_delayed_message_exchange"
+
+	// ExchangeTypeDelayed is the type of the exchanges PluginDelayedMessage adds, which need
+	// an "x-delayed-type" argument with the type of exchange they route like, and delay
+	// messages by their "x-delay" header in milliseconds
+	ExchangeTypeDelayed = "x-delayed-message"
+
+	// ExchangeTypeConsistentHash is the type of the exchanges PluginConsistentHash adds,
+	// where the routing keys of the bindings are the weights of the queues
+	ExchangeTypeConsistentHash = "x-consistent-hash"
+)
+
+const (
+	// rabbitmqPluginsDir is where the image looks for plugins
+	rabbitmqPluginsDir = "/opt/rabbitmq/plugins"
+
+	// rabbitmqEnabledPluginsPath is the file listing the plugins enabled on startup
+	rabbitmqEnabledPluginsPath = "/etc/rabbitmq/enabled_plugins"
+)
+
+// rabbitmqDefaultPlugins are the plugins the image enables, which stay enabled when the
+// enabled plugins file is replaced
+var rabbitmqDefaultPlugins = []string{"rabbitmq_management", "rabbitmq_prometheus"}
+
+// pluginVersion matches the version at the end of a plugin file's name
+var pluginVersion = regexp.MustCompile(`-v?\d[^-]*$`)
+
+// AddPlugins adds the specified plugins to be enabled when the container starts.
+func (r *RabbitMQ) AddPlugins(p ...string) *RabbitMQ {
+	r.Plugins = append(r.Plugins, p...)
+
+	return r
+}
+
+// AddPluginFiles adds the specified .ez plugin files, relative to the GOPATH, to be
+// installed and enabled when the container starts.
+func (r *RabbitMQ) AddPluginFiles(p ...string) *RabbitMQ {
+	r.PluginFiles = append(r.PluginFiles, p...)
+
+	return r
+}
+
+// enabledPlugins returns the plugins to enable, which are the image's, Plugins and the
+// plugins in PluginFiles.
+func (r *RabbitMQ) enabledPlugins() []string {
+	plugins := append([]string{}, rabbitmqDefaultPlugins...)
+	seen := map[string]bool{}
+
+	for _, p := range plugins {
+		seen[p] = true
+	}
+
+	for _, p := range r.Plugins {
+		if !seen[p] {
+			plugins = append(plugins, p)
+			seen[p] = true
+		}
+	}
+
+	for _, f := range r.PluginFiles {
+		p := pluginName(f)
+
+		if !seen[p] {
+			plugins = append(plugins, p)
+			seen[p] = true
+		}
+	}
+
+	return plugins
+}
+
+// installPlugins copies the plugin files and the enabled plugins file into the container,
+// so the plugins are enabled when the broker starts.
+func (r *RabbitMQ) installPlugins(ctx context.Context, containerID string) error {
+	for _, f := range r.PluginFiles {
+		b, err := ioutil.ReadFile(path.Join(GoPath(), f))
+		if err != nil {
+			return err
+		}
+
+		err = copyFileToContainer(ctx, r.Client, containerID, rabbitmqPluginsDir, path.Base(f), b, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	enabled := fmt.Sprintf("[%s].\n", strings.Join(r.enabledPlugins(), ","))
+
+	return copyFileToContainer(ctx, r.Client, containerID, path.Dir(rabbitmqEnabledPluginsPath), path.Base(rabbitmqEnabledPluginsPath), []byte(enabled), 0644)
+}
+
+// checkPlugins checks that the plugins are running on the broker.
+func (r *RabbitMQ) checkPlugins(ctx context.Context, containerID string) error {
+	err := dockerExec(ctx, r.Client, containerID, append([]string{"rabbitmq-plugins", "is_enabled"}, r.enabledPlugins()...))
+	if err != nil {
+		return fmt.Errorf("the plugins aren't all enabled: %s", strings.TrimSpace(err.Error()))
+	}
+
+	return nil
+}
+
+// pluginName returns the name of the plugin in the file, which is the file's name without
+// the version and extension, like rabbitmq_delayed_message_exchange for
+// rabbitmq_delayed_message_exchange-4.0.2.ez.
+func pluginName(file string) string {
+	return pluginVersion.ReplaceAllString(strings.TrimSuffix(path.Base(file), ".ez"), "")
+}
@@ -1,8 +1,14 @@
 package easycontainers
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
 	"time"
 
 	"strconv"
@@ -16,11 +22,72 @@ import (
 	"github.com/docker/go-connections/nat"
 )
 
-// Redis is a containerized version of the specified Go application.
+const (
+	RedisVariantStack = "stack"
+)
+
+// redisConfigPath is where the config file is copied to in the container
+const redisConfigPath = "/usr/local/etc/redis.conf"
+
+// redisImages are the images for each Redis variant, the plain redis image being the default
+var redisImages = map[string]string{
+	"":                "redis:latest",
+	RedisVariantStack: "redis/redis-stack-server:latest",
+}
+
+// Redis is a container using the official redis docker image. The callback runs once
+// redis answers PING.
+//
+// Password is the password of the default user, set with requirepass. If it is empty, no
+// password is needed.
+//
+// Users are ACL users to create, in addition to the default user.
+//
+// MaxMemory is the memory limit, like "100mb", and MaxMemoryPolicy is what redis does when
+// the limit is reached, like "allkeys-lru". If MaxMemory is empty, there is no limit.
+//
+// AppendOnly turns on the append only file, for testing persistence.
+//
+// Config is a map of redis.conf directives, like "notify-keyspace-events", which take
+// precedence over the options above. All of them are written to a config file redis is
+// started with.
+//
+// RDBPath is a path to a dump.rdb file, relative to the GOPATH, that redis loads on startup.
+// Path is a path to a file of redis commands, relative to the GOPATH, which are run once the
+// container is ready. Seed is a map of keys to set in database 0 after that, whichever
+// database the commands selected, and AddSeed adds keys to it. Seeding is finished before
+// the callback runs, and Reset restores the seeded data.
+//
+// Variant picks the image the container runs. The default is the official redis image, and
+// RedisVariantStack uses the redis-stack-server image, which has modules like RediSearch and
+// RedisJSON loaded. SearchIndexes and JSONDocuments, which need RedisVariantStack, are
+// created as part of seeding.
 type Redis struct {
-	Client        *client.Client
-	ContainerName string
-	Port          int
+	Client          *client.Client
+	ContainerName   string
+	Port            int
+	Password        string
+	Users           []RedisUser
+	MaxMemory       string
+	MaxMemoryPolicy string
+	AppendOnly      bool
+	Config          map[string]string
+	RDBPath         string
+	Path            string
+	Seed            map[string]RedisSeed
+	Variant         string
+	SearchIndexes   []RedisSearchIndex
+	JSONDocuments   map[string]interface{}
+
+	snapshot []redisSnapshotKey
+}
+
+// RedisUser is an ACL user. Rules are ACL rules, like "~cache:*" and "+@read", and
+// the user has no permissions without them.
+type RedisUser struct {
+	Name     string
+	Password string
+	Rules    []string
 }
 
 // NewRedis returns a new instance of Redis and the port it will be using.
@@ -60,7 +127,16 @@ func NewRedisWithPort(name string, port int) *Redis {
 // container is stopped and removed.
 func (redis *Redis) Container(f func() error) error {
 	ctx := context.Background()
-	reader, err := redis.Client.ImagePull(ctx, "docker.io/library/redis:latest", types.ImagePullOptions{})
+	image, exists := redisImages[redis.Variant]
+	if !exists {
+		return fmt.Errorf("unknown redis variant %q", redis.Variant)
+	}
+
+	if redis.Variant != RedisVariantStack && (len(redis.SearchIndexes) > 0 || len(redis.JSONDocuments) > 0) {
+		return errors.New("search indexes and JSON documents need the RedisVariantStack variant")
+	}
+
+	reader, err := redis.Client.ImagePull(ctx, "docker.io/"+image, types.ImagePullOptions{})
 	if err != nil {
 		return err
 	}
@@ -74,8 +150,8 @@ func (redis *Redis) Container(f func() error) error {
 	resp, err := redis.Client.ContainerCreate(
 		ctx,
 		&container.Config{
-			Image: "redis:latest",
-			Tty:   true,
+			Image: image,
+			Cmd:   redis.command(),
 		},
 		&container.HostConfig{
 			PortBindings: nat.PortMap{
@@ -100,12 +176,166 @@ func (redis *Redis) Container(f func() error) error {
 		})
 	}()
 
+	configPath := redisConfigPath
+	if redis.Variant == RedisVariantStack {
+		configPath = redisStackConfigPath
+	}
+
+	err = copyFileToContainer(ctx, redis.Client, resp.ID, path.Dir(configPath), path.Base(configPath), []byte(redis.config()), 0644)
+	if err != nil {
+		return err
+	}
+
+	if redis.RDBPath != "" {
+		rdb, err := ioutil.ReadFile(path.Join(GoPath(), redis.RDBPath))
+		if err != nil {
+			return err
+		}
+
+		err = copyFileToContainer(ctx, redis.Client, resp.ID, "/data", "dump.rdb", rdb, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = redis.Client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
 	if err != nil {
 		return err
 	}
 
+	err = waitForRedis(redis.Addr(), "", redis.Password, 1*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	if redis.Variant == RedisVariantStack {
+		err = redis.checkModules()
+		if err != nil {
+			return err
+		}
+	}
+
+	if redis.RDBPath != "" && redis.AppendOnly {
+		// redis only loads the rdb file if the append only file is off when it starts, so it
+		// is turned on once the data is loaded
+		_, err = redis.Do("CONFIG", "SET", "appendonly", "yes")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = redis.seed()
+	if err != nil {
+		return err
+	}
+
 	fmt.Println("successfully created Redis container")
 
 	return f()
 }
+
+// command returns the command the container runs. The redis-stack-server image's default
+// command loads the modules, and starts redis with the config file if there is one at
+// redisStackConfigPath.
+func (redis *Redis) command() []string {
+	if redis.Variant == RedisVariantStack {
+		return nil
+	}
+
+	return []string{"redis-server", redisConfigPath}
+}
+
+// Addr returns the address for connecting to the container from the host.
+func (redis *Redis) Addr() string {
+	return fmt.Sprintf("localhost:%d", redis.Port)
+}
+
+// Do runs a command against the container as the default user and returns the reply.
+// Simple and bulk strings are returned as strings, integers as int64s, arrays as
+// []interface{} and nil replies as nil.
+func (redis *Redis) Do(args ...string) (interface{}, error) {
+	c, err := dialRedis(redis.Addr(), "", redis.Password)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	return c.do(args...)
+}
+
+// AddUsers adds the specified ACL users to be created when the container starts.
+func (redis *Redis) AddUsers(u ...RedisUser) *Redis {
+	redis.Users = append(redis.Users, u...)
+
+	return redis
+}
+
+// config returns the contents of the config file.
+func (redis *Redis) config() string {
+	config := map[string]string{
+		// the image turns protected mode off, but only when redis isn't started with a
+		// config file
+		"protected-mode": "no",
+	}
+
+	if redis.Password != "" {
+		config["requirepass"] = redisQuote(redis.Password)
+	}
+
+	if redis.MaxMemory != "" {
+		config["maxmemory"] = redis.MaxMemory
+	}
+
+	if redis.MaxMemoryPolicy != "" {
+		config["maxmemory-policy"] = redis.MaxMemoryPolicy
+	}
+
+	if redis.AppendOnly && redis.RDBPath == "" {
+		config["appendonly"] = "yes"
+	}
+
+	for k, v := range redis.Config {
+		config[k] = v
+	}
+
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	b := bytes.Buffer{}
+
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s %s\n", k, config[k])
+	}
+
+	for _, u := range redis.Users {
+		fmt.Fprintf(&b, "user %s on", u.Name)
+
+		if u.Password == "" {
+			b.WriteString(" nopass")
+		} else {
+			b.WriteString(" " + redisQuote(">"+u.Password))
+		}
+
+		for _, r := range u.Rules {
+			b.WriteString(" " + redisQuote(r))
+		}
+
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// redisQuote quotes the config file argument if it has characters that would otherwise
+// split it or be read as quotes.
+func redisQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+
+	return strconv.Quote(s)
+}
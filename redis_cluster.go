@@ -0,0 +1,485 @@
+package easycontainers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// redisHost is the address the nodes of the redis topologies listen on and announce, since
+// they use the host's network
+const redisHost = "127.0.0.1"
+
+// RedisCluster is a redis cluster using the official redis docker image. The callback runs
+// once every slot is assigned and every node reports the cluster as ok.
+//
+// Masters is the number of masters, which redis-cli needs at least 3 of to create the
+// cluster, and ReplicasPerMaster is the number of replicas each master has. Ports are the
+// ports of the nodes, with the masters' first when the cluster is created, and BusPorts are
+// the ports the nodes talk to each other on.
+//
+// The nodes use the host's network and announce 127.0.0.1, so the addresses cluster clients
+// are redirected to can be reached from the host. That needs docker on Linux, or a version
+// of Docker Desktop with host networking turned on.
+//
+// Password is set as the password of every node, and Config is a map of redis.conf
+// directives set on every node.
+type RedisCluster struct {
+	Client            *client.Client
+	ContainerName     string
+	Masters           int
+	ReplicasPerMaster int
+	Ports             []int
+	BusPorts          []int
+	Password          string
+	Config            map[string]string
+
+	containerIDs []string
+}
+
+// redisClusterNode is a line of CLUSTER NODES.
+type redisClusterNode struct {
+	id       string
+	port     int
+	master   bool
+	masterID string
+	failed   bool
+}
+
+// NewRedisCluster returns a new instance of RedisCluster and the ports of its nodes.
+func NewRedisCluster(name string, masters, replicasPerMaster int) (c *RedisCluster, ports []int) {
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		panic(err)
+	}
+
+	c = &RedisCluster{
+		Client:            cli,
+		ContainerName:     prefix + "redis-cluster-" + name,
+		Masters:           masters,
+		ReplicasPerMaster: replicasPerMaster,
+	}
+
+	for i := 0; i < masters*(1+replicasPerMaster); i++ {
+		port, err := getFreePort()
+		if err != nil {
+			panic(err)
+		}
+
+		busPort, err := getFreePort()
+		if err != nil {
+			panic(err)
+		}
+
+		c.Ports = append(c.Ports, port)
+		c.BusPorts = append(c.BusPorts, busPort)
+	}
+
+	return c, c.Ports
+}
+
+// Container spins up the nodes, creates the cluster and runs. When the method exits, the
+// containers are stopped and removed.
+func (c *RedisCluster) Container(f func() error) error {
+	if c.Masters < 3 {
+		return fmt.Errorf("a redis cluster needs at least 3 masters, not %d", c.Masters)
+	}
+
+	if len(c.Ports) != c.Masters*(1+c.ReplicasPerMaster) || len(c.BusPorts) != len(c.Ports) {
+		return fmt.Errorf("a redis cluster of %d masters with %d replicas each needs %d ports and bus ports", c.Masters, c.ReplicasPerMaster, c.Masters*(1+c.ReplicasPerMaster))
+	}
+
+	ctx := context.Background()
+
+	err := pullRedis(ctx, c.Client)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, id := range c.containerIDs {
+			removeContainer(ctx, c.Client, id)
+		}
+	}()
+
+	for i, port := range c.Ports {
+		config := map[string]string{
+			"cluster-enabled":      "yes",
+			"cluster-port":         strconv.Itoa(c.BusPorts[i]),
+			"cluster-config-file":  fmt.Sprintf("nodes-%d.conf", port),
+			"cluster-node-timeout": "5000",
+			"cluster-announce-ip":  redisHost,
+		}
+
+		id, err := startRedisNode(ctx, c.Client, fmt.Sprintf("%s-node-%d", c.ContainerName, i), redisNodeConfig(port, c.Password, config, c.Config), redisConfigPath)
+		if err != nil {
+			return err
+		}
+
+		c.containerIDs = append(c.containerIDs, id)
+	}
+
+	for _, port := range c.Ports {
+		err = waitForRedis(redisAddr(port), "", c.Password, 1*time.Minute)
+		if err != nil {
+			return err
+		}
+	}
+
+	cmd := []string{"redis-cli"}
+	if c.Password != "" {
+		cmd = append(cmd, "-a", c.Password, "--no-auth-warning")
+	}
+
+	cmd = append(cmd, "--cluster", "create")
+	cmd = append(cmd, c.Addrs()...)
+	cmd = append(cmd, "--cluster-replicas", strconv.Itoa(c.ReplicasPerMaster), "--cluster-yes")
+
+	err = dockerExec(ctx, c.Client, c.containerIDs[0], cmd)
+	if err != nil {
+		return fmt.Errorf("creating the cluster: %s", err)
+	}
+
+	err = c.waitForCluster()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("successfully created Redis cluster")
+
+	return f()
+}
+
+// Addrs returns the addresses of the nodes, for connecting to the cluster from the host.
+func (c *RedisCluster) Addrs() []string {
+	addrs := make([]string, len(c.Ports))
+	for i, port := range c.Ports {
+		addrs[i] = redisAddr(port)
+	}
+
+	return addrs
+}
+
+// Do runs a command against the node listening on port and returns the reply, without
+// following redirects.
+func (c *RedisCluster) Do(port int, args ...string) (interface{}, error) {
+	conn, err := dialRedis(redisAddr(port), "", c.Password)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.do(args...)
+}
+
+// MasterPorts returns the ports of the nodes that are currently masters, which changes
+// after a failover.
+func (c *RedisCluster) MasterPorts() ([]int, error) {
+	nodes, err := c.nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []int
+
+	for _, n := range nodes {
+		if n.master && !n.failed {
+			ports = append(ports, n.port)
+		}
+	}
+
+	sort.Ints(ports)
+
+	return ports, nil
+}
+
+// Failover promotes a replica of the master listening on port with CLUSTER FAILOVER, and
+// returns the port of the replica once it is a master and the cluster is ok again.
+func (c *RedisCluster) Failover(port int) (int, error) {
+	nodes, err := c.nodes()
+	if err != nil {
+		return 0, err
+	}
+
+	masterID := ""
+
+	for _, n := range nodes {
+		if n.port == port && n.master {
+			masterID = n.id
+		}
+	}
+
+	if masterID == "" {
+		return 0, fmt.Errorf("the node on port %d isn't a master", port)
+	}
+
+	replica := 0
+
+	for _, n := range nodes {
+		if n.masterID == masterID && !n.failed {
+			replica = n.port
+
+			break
+		}
+	}
+
+	if replica == 0 {
+		return 0, fmt.Errorf("the master on port %d has no replica to fail over to", port)
+	}
+
+	_, err = c.Do(replica, "CLUSTER", "FAILOVER")
+	if err != nil {
+		return 0, err
+	}
+
+	err = retryRedis(1*time.Minute, "the replica to be promoted", func() error {
+		reply, err := c.Do(replica, "ROLE")
+		if err != nil {
+			return err
+		}
+
+		role, _ := reply.([]interface{})
+		if len(role) == 0 || role[0] != "master" {
+			return fmt.Errorf("the node on port %d is still a replica", replica)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return replica, c.waitForCluster()
+}
+
+// waitForCluster waits until every node knows every other node and reports the cluster
+// as ok, and every replica is connected to its master.
+func (c *RedisCluster) waitForCluster() error {
+	return retryRedis(1*time.Minute, "the cluster to be ok", func() error {
+		for _, port := range c.Ports {
+			reply, err := c.Do(port, "CLUSTER", "INFO")
+			if err != nil {
+				return err
+			}
+
+			info, _ := reply.(string)
+
+			if !strings.Contains(info, "cluster_state:ok") {
+				return fmt.Errorf("the node on port %d reports the cluster isn't ok", port)
+			}
+
+			if !strings.Contains(info, fmt.Sprintf("cluster_known_nodes:%d\r", len(c.Ports))) {
+				return fmt.Errorf("the node on port %d doesn't know every node yet", port)
+			}
+
+			err = checkReplicaLink(c.Do, port)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// nodes returns the nodes as the first node that answers sees them.
+func (c *RedisCluster) nodes() ([]redisClusterNode, error) {
+	var err error
+
+	for _, port := range c.Ports {
+		var reply interface{}
+
+		reply, err = c.Do(port, "CLUSTER", "NODES")
+		if err == nil {
+			s, _ := reply.(string)
+
+			return parseClusterNodes(s)
+		}
+	}
+
+	return nil, err
+}
+
+// parseClusterNodes parses the reply to CLUSTER NODES, where each line is a node's id,
+// address, flags and master, followed by fields that aren't needed here.
+func parseClusterNodes(s string) ([]redisClusterNode, error) {
+	var nodes []redisClusterNode
+
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("malformed CLUSTER NODES line: %q", line)
+		}
+
+		// the address is ip:port@busport, followed by ,hostname if the node has one
+		addr := strings.SplitN(fields[1], "@", 2)[0]
+
+		port, err := strconv.Atoi(addr[strings.LastIndex(addr, ":")+1:])
+		if err != nil {
+			return nil, fmt.Errorf("malformed CLUSTER NODES line: %q", line)
+		}
+
+		n := redisClusterNode{
+			id:   fields[0],
+			port: port,
+		}
+
+		for _, flag := range strings.Split(fields[2], ",") {
+			switch flag {
+			case "master":
+				n.master = true
+			case "fail", "fail?", "noaddr":
+				n.failed = true
+			}
+		}
+
+		if fields[3] != "-" {
+			n.masterID = fields[3]
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+// pullRedis pulls the redis image for the topologies.
+func pullRedis(ctx context.Context, client *client.Client) error {
+	reader, err := client.ImagePull(ctx, "docker.io/library/redis:latest", types.ImagePullOptions{})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(os.Stdout, reader)
+
+	return err
+}
+
+// startRedisNode starts a redis container on the host's network with the config file
+// copied to configPath, and returns its id. If the config file is a sentinel's, the node is
+// started as a sentinel.
+func startRedisNode(ctx context.Context, client *client.Client, name, config, configPath string) (string, error) {
+	cmd := []string{"redis-server", configPath}
+	if configPath == redisSentinelConfigPath {
+		cmd = append(cmd, "--sentinel")
+	}
+
+	resp, err := client.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image: "redis:latest",
+			Cmd:   cmd,
+		},
+		&container.HostConfig{
+			NetworkMode: "host",
+		},
+		nil,
+		name,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	err = copyFileToContainer(ctx, client, resp.ID, path.Dir(configPath), path.Base(configPath), []byte(config), 0644)
+	if err == nil {
+		err = client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	}
+
+	if err != nil {
+		removeContainer(ctx, client, resp.ID)
+
+		return "", err
+	}
+
+	return resp.ID, nil
+}
+
+// redisNodeConfig returns the config file of a node of a topology listening on port. The
+// password is needed both to connect to the node and for it to connect to its master.
+// Later configs take precedence over earlier ones.
+func redisNodeConfig(port int, password string, configs ...map[string]string) string {
+	config := map[string]string{
+		"port":           strconv.Itoa(port),
+		"bind":           redisHost,
+		"protected-mode": "no",
+	}
+
+	if password != "" {
+		config["requirepass"] = redisQuote(password)
+		config["masterauth"] = redisQuote(password)
+	}
+
+	for _, c := range configs {
+		for k, v := range c {
+			config[k] = v
+		}
+	}
+
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	b := bytes.Buffer{}
+
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s %s\n", k, config[k])
+	}
+
+	return b.String()
+}
+
+// checkReplicaLink returns an error if the node on port is a replica that isn't
+// connected to its master yet, running ROLE with do.
+func checkReplicaLink(do func(port int, args ...string) (interface{}, error), port int) error {
+	reply, err := do(port, "ROLE")
+	if err != nil {
+		return err
+	}
+
+	// a replica's role is slave, the master's ip and port, the state of the link and the
+	// replication offset
+	role, _ := reply.([]interface{})
+	if len(role) == 5 && role[0] == "slave" && role[3] != "connected" {
+		return fmt.Errorf("the replica on port %d isn't connected to its master yet", port)
+	}
+
+	return nil
+}
+
+func redisAddr(port int) string {
+	return fmt.Sprintf("%s:%d", redisHost, port)
+}
+
+// retryRedis runs check every half second until it succeeds, or until the timeout passes,
+// in which case the last error is returned.
+func retryRedis(timeout time.Duration, waitingFor string, check func() error) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s, the last error was: %s", waitingFor, err)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
@@ -0,0 +1,392 @@
+package easycontainers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisKeyspaceLine matches the lines of INFO keyspace, capturing the database number
+var redisKeyspaceLine = regexp.MustCompile(`(?m)^db(\d+):`)
+
+// RedisSeed is a key to set when the container starts. Value is a string, a []string,
+// which is pushed to a list, or a map[string]string, which is set as a hash. If TTL isn't
+// zero, the key expires after it.
+type RedisSeed struct {
+	Value interface{}
+	TTL   time.Duration
+}
+
+// redisSnapshotKey is a key as it was once the container was seeded.
+type redisSnapshotKey struct {
+	db   string
+	key  string
+	dump string
+	ttl  int64
+}
+
+// AddSeed adds a key to set when the container starts.
+func (redis *Redis) AddSeed(key string, value interface{}, ttl time.Duration) *Redis {
+	if redis.Seed == nil {
+		redis.Seed = map[string]RedisSeed{}
+	}
+
+	redis.Seed[key] = RedisSeed{
+		Value: value,
+		TTL:   ttl,
+	}
+
+	return redis
+}
+
+// Reset flushes every database and restores the keys the container was seeded with, so
+// each test can start from the same data. The keys' TTLs are restored to what they were
+// when the container finished seeding, and the search indexes are dropped and created again.
+func (redis *Redis) Reset() error {
+	c, err := dialRedis(redis.Addr(), "", redis.Password)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if redis.Variant == RedisVariantStack {
+		err = dropSearchIndexes(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = c.do("FLUSHALL")
+	if err != nil {
+		return err
+	}
+
+	// the indexes are created before the keys are restored, so the keys are indexed as
+	// they are written
+	err = createSearchIndexes(c, redis.SearchIndexes)
+	if err != nil {
+		return err
+	}
+
+	db := ""
+
+	for _, k := range redis.snapshot {
+		if k.db != db {
+			_, err = c.do("SELECT", k.db)
+			if err != nil {
+				return err
+			}
+
+			db = k.db
+		}
+
+		_, err = c.do("RESTORE", k.key, strconv.FormatInt(k.ttl, 10), k.dump)
+		if err != nil {
+			return fmt.Errorf("restoring %s: %s", k.key, err)
+		}
+	}
+
+	return nil
+}
+
+// seed creates the search indexes, runs the commands in Path and sets the keys in Seed and
+// the JSON documents, then takes the snapshot Reset restores.
+func (redis *Redis) seed() error {
+	c, err := dialRedis(redis.Addr(), "", redis.Password)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	err = createSearchIndexes(c, redis.SearchIndexes)
+	if err != nil {
+		return err
+	}
+
+	if redis.Path != "" {
+		err = runRedisCommands(c, redis.Path)
+		if err != nil {
+			return err
+		}
+
+		// the commands can SELECT another database, and the seed keys go into 0
+		_, err = c.do("SELECT", "0")
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(redis.Seed) > 0 {
+		err = seedRedis(c, redis.Seed)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(redis.JSONDocuments) > 0 {
+		err = seedJSON(c, redis.JSONDocuments)
+		if err != nil {
+			return err
+		}
+	}
+
+	redis.snapshot, err = snapshotRedis(c)
+
+	return err
+}
+
+// runRedisCommands runs the commands in the file at commandsPath, relative to the GOPATH,
+// one per line. Arguments are split like redis-cli splits them, so they can be quoted, and
+// blank lines and lines starting with # are skipped.
+func runRedisCommands(c *respConn, commandsPath string) error {
+	b, err := ioutil.ReadFile(path.Join(GoPath(), commandsPath))
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(b)))
+	scanner.Buffer(make([]byte, 64*1024), 512*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		args, err := splitRedisArgs(text)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %s", commandsPath, line, err)
+		}
+
+		_, err = c.do(args...)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %s", commandsPath, line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// seedRedis sets the keys, in sorted order so that the order is the same every time.
+func seedRedis(c *respConn, seed map[string]RedisSeed) error {
+	keys := make([]string, 0, len(seed))
+	for k := range seed {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := seed[k]
+
+		var args []string
+
+		switch v := s.Value.(type) {
+		case string:
+			args = []string{"SET", k, v}
+		case []string:
+			args = append([]string{"RPUSH", k}, v...)
+		case map[string]string:
+			fields := make([]string, 0, len(v))
+			for f := range v {
+				fields = append(fields, f)
+			}
+
+			sort.Strings(fields)
+
+			args = []string{"HSET", k}
+			for _, f := range fields {
+				args = append(args, f, v[f])
+			}
+		default:
+			return fmt.Errorf("seed %s has an unsupported value of type %T, expected a string, []string or map[string]string", k, s.Value)
+		}
+
+		_, err := c.do(args...)
+		if err != nil {
+			return fmt.Errorf("seeding %s: %s", k, err)
+		}
+
+		if s.TTL > 0 {
+			_, err = c.do("PEXPIRE", k, strconv.FormatInt(int64(s.TTL/time.Millisecond), 10))
+			if err != nil {
+				return fmt.Errorf("seeding %s: %s", k, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// snapshotRedis dumps every key in every database, along with its remaining TTL.
+func snapshotRedis(c *respConn) ([]redisSnapshotKey, error) {
+	reply, err := c.do("INFO", "keyspace")
+	if err != nil {
+		return nil, err
+	}
+
+	info, _ := reply.(string)
+
+	var snapshot []redisSnapshotKey
+
+	for _, match := range redisKeyspaceLine.FindAllStringSubmatch(info, -1) {
+		db := match[1]
+
+		_, err = c.do("SELECT", db)
+		if err != nil {
+			return nil, err
+		}
+
+		reply, err = c.do("KEYS", "*")
+		if err != nil {
+			return nil, err
+		}
+
+		keys, _ := reply.([]interface{})
+
+		for _, k := range keys {
+			key, _ := k.(string)
+
+			dump, err := c.do("DUMP", key)
+			if err != nil {
+				return nil, err
+			}
+
+			// the key expired since KEYS returned it
+			if dump == nil {
+				continue
+			}
+
+			pttl, err := c.do("PTTL", key)
+			if err != nil {
+				return nil, err
+			}
+
+			ttl, _ := pttl.(int64)
+			if ttl < 0 {
+				ttl = 0
+			}
+
+			snapshot = append(snapshot, redisSnapshotKey{
+				db:   db,
+				key:  key,
+				dump: dump.(string),
+				ttl:  ttl,
+			})
+		}
+	}
+
+	_, err = c.do("SELECT", "0")
+
+	return snapshot, err
+}
+
+// splitRedisArgs splits a line into arguments the way redis-cli does. Arguments are
+// separated by whitespace and can be double quoted, with escapes like \n and \x00, or
+// single quoted, where \' is the only escape.
+func splitRedisArgs(line string) ([]string, error) {
+	var args []string
+
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+
+			continue
+		}
+
+		var (
+			arg   []byte
+			quote = line[i]
+		)
+
+		if quote != '"' && quote != '\'' {
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				arg = append(arg, line[i])
+				i++
+			}
+
+			args = append(args, string(arg))
+
+			continue
+		}
+
+		i++
+		closed := false
+
+		for i < len(line) && !closed {
+			c := line[i]
+
+			switch {
+			case c == quote:
+				closed = true
+				i++
+			case c == '\\' && i+1 < len(line) && quote == '\'':
+				if line[i+1] == '\'' {
+					arg = append(arg, '\'')
+					i += 2
+				} else {
+					arg = append(arg, c)
+					i++
+				}
+			case c == '\\' && i+1 < len(line):
+				escaped, n, err := redisEscape(line[i+1:])
+				if err != nil {
+					return nil, err
+				}
+
+				arg = append(arg, escaped)
+				i += 1 + n
+			default:
+				arg = append(arg, c)
+				i++
+			}
+		}
+
+		if !closed {
+			return nil, errors.New("unbalanced quotes")
+		}
+
+		if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			return nil, errors.New("closing quote must be followed by a space")
+		}
+
+		args = append(args, string(arg))
+	}
+
+	return args, nil
+}
+
+// redisEscape reads the escape sequence at the start of s, which follows a backslash in a
+// double quoted argument, and returns the byte it stands for and its length.
+func redisEscape(s string) (byte, int, error) {
+	switch s[0] {
+	case 'n':
+		return '\n', 1, nil
+	case 'r':
+		return '\r', 1, nil
+	case 't':
+		return '\t', 1, nil
+	case 'b':
+		return '\b', 1, nil
+	case 'a':
+		return '\a', 1, nil
+	case 'x':
+		if len(s) >= 3 {
+			b, err := strconv.ParseUint(s[1:3], 16, 8)
+			if err == nil {
+				return byte(b), 3, nil
+			}
+		}
+
+		return 'x', 1, nil
+	default:
+		return s[0], 1, nil
+	}
+}
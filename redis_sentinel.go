@@ -0,0 +1,332 @@
+package easycontainers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// redisSentinelConfigPath is where the config file is copied to in the sentinels. It is in
+// the data directory because sentinels rewrite their config file, and the entrypoint makes
+// that directory writable by redis.
+const redisSentinelConfigPath = "/data/sentinel.conf"
+
+// RedisSentinel is a redis master with replicas, monitored by sentinels, using the
+// official redis docker image. The callback runs once the replicas are connected to the
+// master and every sentinel knows about the replicas and the other sentinels.
+//
+// MasterName is the name the sentinels monitor the master as, which defaults to mymaster.
+// Port is the master's port, ReplicaPorts are the replicas' and SentinelPorts are the
+// sentinels'. A majority of the sentinels have to agree the master is down to fail over.
+//
+// Like RedisCluster, the nodes use the host's network and announce 127.0.0.1, so the
+// addresses the sentinels return can be reached from the host.
+//
+// Password is set as the password of the master and replicas, and the sentinels use it to
+// connect to them. The sentinels themselves don't need a password. Config is a map of
+// redis.conf directives set on the master and replicas.
+type RedisSentinel struct {
+	Client        *client.Client
+	ContainerName string
+	MasterName    string
+	Port          int
+	ReplicaPorts  []int
+	SentinelPorts []int
+	Password      string
+	Config        map[string]string
+
+	containerIDs []string
+}
+
+// NewRedisSentinel returns a new instance of RedisSentinel and the ports of its sentinels.
+func NewRedisSentinel(name string, replicas, sentinels int) (s *RedisSentinel, sentinelPorts []int) {
+	c, err := client.NewEnvClient()
+	if err != nil {
+		panic(err)
+	}
+
+	port, err := getFreePort()
+	if err != nil {
+		panic(err)
+	}
+
+	s = &RedisSentinel{
+		Client:        c,
+		ContainerName: prefix + "redis-sentinel-" + name,
+		MasterName:    "mymaster",
+		Port:          port,
+	}
+
+	for i := 0; i < replicas; i++ {
+		port, err := getFreePort()
+		if err != nil {
+			panic(err)
+		}
+
+		s.ReplicaPorts = append(s.ReplicaPorts, port)
+	}
+
+	for i := 0; i < sentinels; i++ {
+		port, err := getFreePort()
+		if err != nil {
+			panic(err)
+		}
+
+		s.SentinelPorts = append(s.SentinelPorts, port)
+	}
+
+	return s, s.SentinelPorts
+}
+
+// Container spins up the master, replicas and sentinels, and runs. When the method exits,
+// the containers are stopped and removed.
+func (s *RedisSentinel) Container(f func() error) error {
+	if len(s.SentinelPorts) == 0 {
+		return errors.New("redis sentinel needs at least 1 sentinel")
+	}
+
+	if s.MasterName == "" {
+		s.MasterName = "mymaster"
+	}
+
+	ctx := context.Background()
+
+	err := pullRedis(ctx, s.Client)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, id := range s.containerIDs {
+			removeContainer(ctx, s.Client, id)
+		}
+	}()
+
+	err = s.startNode(ctx, s.ContainerName+"-master", redisNodeConfig(s.Port, s.Password, s.Config), redisConfigPath)
+	if err != nil {
+		return err
+	}
+
+	for i, port := range s.ReplicaPorts {
+		replicaOf := map[string]string{
+			"replicaof": fmt.Sprintf("%s %d", redisHost, s.Port),
+		}
+
+		err = s.startNode(ctx, fmt.Sprintf("%s-replica-%d", s.ContainerName, i), redisNodeConfig(port, s.Password, s.Config, replicaOf), redisConfigPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = retryRedis(1*time.Minute, "the replicas to connect to the master", func() error {
+		for _, port := range append([]int{s.Port}, s.ReplicaPorts...) {
+			err := checkReplicaLink(s.Do, port)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, port := range s.SentinelPorts {
+		err = s.startNode(ctx, fmt.Sprintf("%s-sentinel-%d", s.ContainerName, i), s.sentinelConfig(port), redisSentinelConfigPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.waitForSentinels()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("successfully created Redis sentinel")
+
+	return f()
+}
+
+// SentinelAddrs returns the addresses of the sentinels, for connecting to them from the host.
+func (s *RedisSentinel) SentinelAddrs() []string {
+	addrs := make([]string, len(s.SentinelPorts))
+	for i, port := range s.SentinelPorts {
+		addrs[i] = redisAddr(port)
+	}
+
+	return addrs
+}
+
+// MasterAddr asks the sentinels for the address of the current master, which changes after
+// a failover.
+func (s *RedisSentinel) MasterAddr() (string, error) {
+	var err error
+
+	for _, port := range s.SentinelPorts {
+		var reply interface{}
+
+		reply, err = s.Do(port, "SENTINEL", "GET-MASTER-ADDR-BY-NAME", s.MasterName)
+		if err != nil {
+			continue
+		}
+
+		addr, _ := reply.([]interface{})
+		if len(addr) != 2 {
+			return "", fmt.Errorf("unexpected reply to SENTINEL GET-MASTER-ADDR-BY-NAME: %v", reply)
+		}
+
+		return fmt.Sprintf("%s:%s", addr[0], addr[1]), nil
+	}
+
+	return "", err
+}
+
+// Do runs a command against the master, replica or sentinel listening on port and returns
+// the reply.
+func (s *RedisSentinel) Do(port int, args ...string) (interface{}, error) {
+	password := s.Password
+
+	for _, p := range s.SentinelPorts {
+		if p == port {
+			password = ""
+		}
+	}
+
+	c, err := dialRedis(redisAddr(port), "", password)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	return c.do(args...)
+}
+
+// Failover forces the sentinels to fail over with SENTINEL FAILOVER, and returns the
+// address of the new master once every sentinel agrees on it and the replicas are
+// connected to it.
+func (s *RedisSentinel) Failover() (string, error) {
+	old, err := s.MasterAddr()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.Do(s.SentinelPorts[0], "SENTINEL", "FAILOVER", s.MasterName)
+	if err != nil {
+		return "", err
+	}
+
+	addr := ""
+
+	err = retryRedis(1*time.Minute, "the failover", func() error {
+		addr = ""
+
+		for _, port := range s.SentinelPorts {
+			reply, err := s.Do(port, "SENTINEL", "GET-MASTER-ADDR-BY-NAME", s.MasterName)
+			if err != nil {
+				return err
+			}
+
+			a, _ := reply.([]interface{})
+			if len(a) != 2 {
+				return fmt.Errorf("unexpected reply to SENTINEL GET-MASTER-ADDR-BY-NAME: %v", reply)
+			}
+
+			current := fmt.Sprintf("%s:%s", a[0], a[1])
+
+			if current == old {
+				return fmt.Errorf("the sentinel on port %d still reports %s as the master", port, old)
+			}
+
+			if addr != "" && current != addr {
+				return errors.New("the sentinels don't agree on the new master yet")
+			}
+
+			addr = current
+		}
+
+		for _, port := range append([]int{s.Port}, s.ReplicaPorts...) {
+			err := checkReplicaLink(s.Do, port)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return addr, err
+}
+
+// startNode starts a master, replica or sentinel.
+func (s *RedisSentinel) startNode(ctx context.Context, name, config, configPath string) error {
+	id, err := startRedisNode(ctx, s.Client, name, config, configPath)
+	if err != nil {
+		return err
+	}
+
+	s.containerIDs = append(s.containerIDs, id)
+
+	return nil
+}
+
+// sentinelConfig returns the config file of the sentinel listening on port.
+func (s *RedisSentinel) sentinelConfig(port int) string {
+	quorum := len(s.SentinelPorts)/2 + 1
+
+	config := fmt.Sprintf(
+		"port %d\nbind %s\nprotected-mode no\nsentinel monitor %s %s %d %d\nsentinel down-after-milliseconds %s 5000\nsentinel failover-timeout %s 30000\n",
+		port,
+		redisHost,
+		s.MasterName,
+		redisHost,
+		s.Port,
+		quorum,
+		s.MasterName,
+		s.MasterName,
+	)
+
+	if s.Password != "" {
+		config += fmt.Sprintf("sentinel auth-pass %s %s\n", s.MasterName, redisQuote(s.Password))
+	}
+
+	return config
+}
+
+// waitForSentinels waits until every sentinel has discovered the replicas and the other
+// sentinels.
+func (s *RedisSentinel) waitForSentinels() error {
+	return retryRedis(1*time.Minute, "the sentinels to discover each other", func() error {
+		for _, port := range s.SentinelPorts {
+			reply, err := s.Do(port, "SENTINEL", "MASTER", s.MasterName)
+			if err != nil {
+				return err
+			}
+
+			// the reply is a flat list of field names and values
+			fields, _ := reply.([]interface{})
+			master := map[string]string{}
+
+			for i := 0; i+1 < len(fields); i += 2 {
+				k, _ := fields[i].(string)
+				v, _ := fields[i+1].(string)
+				master[k] = v
+			}
+
+			if master["num-slaves"] != strconv.Itoa(len(s.ReplicaPorts)) {
+				return fmt.Errorf("the sentinel on port %d knows about %s of the %d replicas", port, master["num-slaves"], len(s.ReplicaPorts))
+			}
+
+			if master["num-other-sentinels"] != strconv.Itoa(len(s.SentinelPorts)-1) {
+				return fmt.Errorf("the sentinel on port %d knows about %s of the %d other sentinels", port, master["num-other-sentinels"], len(s.SentinelPorts)-1)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,179 @@
+package easycontainers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisStackConfigPath is where the redis-stack-server image's entrypoint looks for a
+// config file
+const redisStackConfigPath = "/redis-stack.conf"
+
+// redisStackModules are the names of the modules RedisVariantStack has to have loaded
+var redisStackModules = []string{"search", "ReJSON"}
+
+// RedisSearchIndex is a RediSearch index, created with FT.CREATE. On is the type of key it
+// indexes, HASH or JSON, and is HASH if it is empty. Prefixes are the prefixes of the keys it
+// indexes, all keys if there are none. Schema is the arguments following SCHEMA, like
+// "$.name", "AS", "name", "TEXT".
+type RedisSearchIndex struct {
+	Name     string
+	On       string
+	Prefixes []string
+	Schema   []string
+}
+
+// AddSearchIndexes adds the specified RediSearch indexes to be created when the container
+// starts.
+func (redis *Redis) AddSearchIndexes(i ...RedisSearchIndex) *Redis {
+	redis.SearchIndexes = append(redis.SearchIndexes, i...)
+
+	return redis
+}
+
+// AddJSONDocument adds a RedisJSON document to be set when the container starts. The value
+// is marshalled with encoding/json, so it can be a struct, a map or a json.RawMessage.
+func (redis *Redis) AddJSONDocument(key string, value interface{}) *Redis {
+	if redis.JSONDocuments == nil {
+		redis.JSONDocuments = map[string]interface{}{}
+	}
+
+	redis.JSONDocuments[key] = value
+
+	return redis
+}
+
+// checkModules checks that the modules of RedisVariantStack are loaded.
+func (redis *Redis) checkModules() error {
+	reply, err := redis.Do("MODULE", "LIST")
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]bool{}
+
+	// each module is a flat list of field names and values
+	modules, _ := reply.([]interface{})
+	for _, m := range modules {
+		fields, _ := m.([]interface{})
+
+		for i := 0; i+1 < len(fields); i += 2 {
+			if fields[i] == "name" {
+				name, _ := fields[i+1].(string)
+				loaded[name] = true
+			}
+		}
+	}
+
+	var missing []string
+
+	for _, m := range redisStackModules {
+		if !loaded[m] {
+			missing = append(missing, m)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("the redis modules %s aren't loaded", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// createSearchIndexes creates the indexes and waits until they have indexed the keys that
+// already exist.
+func createSearchIndexes(c *respConn, indexes []RedisSearchIndex) error {
+	for _, i := range indexes {
+		args := []string{"FT.CREATE", i.Name}
+
+		if i.On != "" {
+			args = append(args, "ON", i.On)
+		}
+
+		if len(i.Prefixes) > 0 {
+			args = append(args, "PREFIX", strconv.Itoa(len(i.Prefixes)))
+			args = append(args, i.Prefixes...)
+		}
+
+		args = append(args, "SCHEMA")
+		args = append(args, i.Schema...)
+
+		_, err := c.do(args...)
+		if err != nil {
+			return fmt.Errorf("creating search index %s: %s", i.Name, err)
+		}
+	}
+
+	for _, i := range indexes {
+		err := retryRedis(1*time.Minute, "search index "+i.Name+" to finish indexing", func() error {
+			reply, err := c.do("FT.INFO", i.Name)
+			if err != nil {
+				return err
+			}
+
+			// the reply is a flat list of field names and values
+			fields, _ := reply.([]interface{})
+			for f := 0; f+1 < len(fields); f += 2 {
+				if fields[f] == "indexing" && fmt.Sprint(fields[f+1]) != "0" {
+					return fmt.Errorf("search index %s is still indexing", i.Name)
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropSearchIndexes drops every search index, leaving the keys they index.
+func dropSearchIndexes(c *respConn) error {
+	reply, err := c.do("FT._LIST")
+	if err != nil {
+		return err
+	}
+
+	indexes, _ := reply.([]interface{})
+	for _, i := range indexes {
+		name, _ := i.(string)
+
+		_, err = c.do("FT.DROPINDEX", name)
+		if err != nil {
+			return fmt.Errorf("dropping search index %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// seedJSON sets the documents at the root path, in sorted order so that the order is the
+// same every time.
+func seedJSON(c *respConn, documents map[string]interface{}) error {
+	keys := make([]string, 0, len(documents))
+	for k := range documents {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		b, err := json.Marshal(documents[k])
+		if err != nil {
+			return fmt.Errorf("seeding %s: %s", k, err)
+		}
+
+		_, err = c.do("JSON.SET", k, "$", string(b))
+		if err != nil {
+			return fmt.Errorf("seeding %s: %s", k, err)
+		}
+	}
+
+	return nil
+}
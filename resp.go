@@ -0,0 +1,190 @@
+package easycontainers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisError is an error reply from redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// respConn is a minimal client for the redis protocol, so the containers can talk to redis
+// from the host without depending on a redis client library.
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialRedis connects to redis, authenticating if password isn't empty. The user can be
+// empty to authenticate as the default user.
+func dialRedis(addr, user, password string) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &respConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+
+	if password != "" {
+		args := []string{"AUTH", password}
+		if user != "" {
+			args = []string{"AUTH", user, password}
+		}
+
+		_, err = c.do(args...)
+		if err != nil {
+			conn.Close()
+
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *respConn) Close() error {
+	return c.conn.Close()
+}
+
+// do sends the command and returns the reply. Simple strings and bulk strings are returned
+// as strings, integers as int64s, arrays as []interface{} and nil bulk strings and arrays as
+// nil. Error replies are returned as the error.
+func (c *respConn) do(args ...string) (interface{}, error) {
+	err := c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(c.conn)
+
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.read()
+	if e, ok := reply.(redisError); ok && err == nil {
+		return nil, e
+	}
+
+	return reply, err
+}
+
+// read reads a reply. Error replies are returned as a redisError value, so that errors
+// nested in arrays don't stop the rest of the array from being read.
+func (c *respConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply from redis: %q", line)
+	}
+
+	kind, rest := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return redisError(rest), nil
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		b := make([]byte, n+2)
+
+		_, err = io.ReadFull(c.r, b)
+		if err != nil {
+			return nil, err
+		}
+
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		items := make([]interface{}, n)
+
+		for i := range items {
+			items[i], err = c.read()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return items, nil
+	default:
+		return nil, errors.New("unsupported reply from redis: " + line)
+	}
+}
+
+// waitForRedis waits until redis answers PING, retrying every half second until the
+// timeout passes.
+func waitForRedis(addr, user, password string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := pingRedis(addr, user, password)
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for redis to answer PING, the last error was: %s", err)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func pingRedis(addr, user, password string) error {
+	c, err := dialRedis(addr, user, password)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	reply, err := c.do("PING")
+	if err != nil {
+		return err
+	}
+
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected reply to PING: %v", reply)
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+package easycontainers
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	// drivers for connecting to the sql containers from the host
+	_ "github.com/denisenkom/go-mssqldb"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+// sqlDialect holds the bits that differ between the sql databases we support, so
+// the logic that runs against them from the host only has to be written once.
+type sqlDialect struct {
+	// driver is the name of the database/sql driver
+	driver string
+
+	// bindVar returns the placeholder for the nth (starting at 1) query argument
+	bindVar func(n int) string
+
+	// quoteChars are the opening and closing characters used to quote identifiers
+	quoteChars [2]string
+
+	// migrationsTable is the table used to keep track of the applied migrations
+	migrationsTable string
+
+	// createMigrationsTable creates migrationsTable if it doesn't exist yet
+	createMigrationsTable string
+
+	// foreignKeyChecks returns the statements that turn foreign key checks on or off
+	// for the tables. Depending on the database, the change lasts until the end of the
+	// transaction or of the session, so resetSession has to undo the session ones.
+	foreignKeyChecks func(tables []string, enabled bool) []string
+
+	// identityColumn returns the query for the name of the table's identity column,
+	// for databases that only take explicit values for it with identityInsert on
+	identityColumn func(table string) string
+
+	// identityInsert returns the statement that allows or disallows explicit values
+	// for the table's identity column
+	identityInsert func(table string, enabled bool) string
+
+	// resetSession returns the statements that undo the session settings the fixtures
+	// change for the tables, which outlive the transaction even if it is rolled back
+	resetSession func(tables []string) []string
+
+	// batches splits a script into the batches to run separately, for databases whose
+	// scripts use batch separators. If it is nil, the script runs as a whole.
+	batches func(script string) []string
+
+	// startupError matches the error printed when the startup sql fails, capturing the
+	// line number and the server's message
+	startupError *regexp.Regexp
+}
+
+var (
+	mysqlDialect = sqlDialect{
+		driver: "mysql",
+		bindVar: func(int) string {
+			return "?"
+		},
+		quoteChars:      [2]string{"`", "`"},
+		migrationsTable: "mysql.schema_migrations",
+		createMigrationsTable: `CREATE TABLE IF NOT EXISTS mysql.schema_migrations (
+			version BIGINT NOT NULL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		foreignKeyChecks: func(tables []string, enabled bool) []string {
+			if enabled {
+				return []string{"SET FOREIGN_KEY_CHECKS = 1"}
+			}
+
+			return []string{"SET FOREIGN_KEY_CHECKS = 0"}
+		},
+		resetSession: func([]string) []string {
+			return []string{"SET FOREIGN_KEY_CHECKS = 1"}
+		},
+		startupError: regexp.MustCompile(`ERROR \d+ \(\w+\) at line (\d+)(?: in file: '[^']*')?: ([^\r\n]*)`),
+	}
+
+	postgresDialect = sqlDialect{
+		driver: "postgres",
+		bindVar: func(n int) string {
+			return fmt.Sprintf("$%d", n)
+		},
+		quoteChars:      [2]string{`"`, `"`},
+		migrationsTable: "public.schema_migrations",
+		createMigrationsTable: `CREATE TABLE IF NOT EXISTS public.schema_migrations (
+			version BIGINT NOT NULL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT now()
+		)`,
+		foreignKeyChecks: func(tables []string, enabled bool) []string {
+			// foreign keys are enforced by triggers, which don't fire for replicas
+			if enabled {
+				return []string{"SET LOCAL session_replication_role = DEFAULT"}
+			}
+
+			return []string{"SET LOCAL session_replication_role = replica"}
+		},
+		// SET LOCAL ends with the transaction, so there is nothing to reset
+		resetSession: func([]string) []string {
+			return nil
+		},
+		startupError: regexp.MustCompile(`psql:[^:]*:(\d+): ERROR:\s+([^\r\n]*)`),
+	}
+
+	sqlServerDialect = sqlDialect{
+		driver: "sqlserver",
+		bindVar: func(n int) string {
+			return fmt.Sprintf("@p%d", n)
+		},
+		quoteChars:      [2]string{"[", "]"},
+		migrationsTable: "master.dbo.schema_migrations",
+		createMigrationsTable: `IF OBJECT_ID('master.dbo.schema_migrations') IS NULL
+			CREATE TABLE master.dbo.schema_migrations (
+				version BIGINT NOT NULL PRIMARY KEY,
+				name NVARCHAR(255) NOT NULL,
+				applied_at DATETIME2 NOT NULL DEFAULT SYSUTCDATETIME()
+			)`,
+		foreignKeyChecks: func(tables []string, enabled bool) []string {
+			stmts := make([]string, len(tables))
+
+			for i, t := range tables {
+				if enabled {
+					stmts[i] = fmt.Sprintf("ALTER TABLE %s WITH CHECK CHECK CONSTRAINT ALL", t)
+				} else {
+					stmts[i] = fmt.Sprintf("ALTER TABLE %s NOCHECK CONSTRAINT ALL", t)
+				}
+			}
+
+			return stmts
+		},
+		identityColumn: sqlServerIdentityColumn,
+		identityInsert: sqlServerIdentityInsert,
+		// disabling constraints is rolled back with the transaction, but IDENTITY_INSERT
+		// isn't, and only one table per session can have it on
+		resetSession: func(tables []string) []string {
+			stmts := make([]string, len(tables))
+
+			for i, t := range tables {
+				stmts[i] = fmt.Sprintf(
+					"IF EXISTS (%s) %s",
+					sqlServerIdentityColumn(t),
+					sqlServerIdentityInsert(t, false),
+				)
+			}
+
+			return stmts
+		},
+		batches: tsqlBatches,
+	}
+)
+
+// sqlServerIdentityColumn selects the name of the table's identity column. The catalog
+// views only cover the current database, so for a database qualified table like
+// [blog].[dbo].[authors] the query reads the one in blog.
+func sqlServerIdentityColumn(table string) string {
+	var catalog string
+
+	parts := strings.Split(table, "].[")
+	if len(parts) == 3 {
+		catalog = parts[0] + "]."
+	}
+
+	return fmt.Sprintf(
+		"SELECT name FROM %ssys.identity_columns WHERE object_id = OBJECT_ID('%s')",
+		catalog,
+		strings.Replace(table, "'", "''", -1),
+	)
+}
+
+// sqlServerIdentityInsert allows or disallows explicit values for the table's identity
+// column.
+func sqlServerIdentityInsert(table string, enabled bool) string {
+	state := "OFF"
+	if enabled {
+		state = "ON"
+	}
+
+	return fmt.Sprintf("SET IDENTITY_INSERT %s %s", table, state)
+}
+
+// quote quotes each part of a (possibly schema qualified) identifier, so
+// blog.authors becomes `blog`.`authors` in mysql.
+func (d sqlDialect) quote(ident string) string {
+	parts := strings.Split(ident, ".")
+
+	for i, p := range parts {
+		p = strings.Replace(p, d.quoteChars[1], d.quoteChars[1]+d.quoteChars[1], -1)
+		parts[i] = d.quoteChars[0] + p + d.quoteChars[1]
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// waitForDB makes sure the database accepts connections from the host. The container
+// healthchecks run inside the container, and the database can still be unreachable
+// through the published port for a moment after they pass.
+func waitForDB(d sqlDialect, dsn string) error {
+	db, err := openDB(d.driver, dsn, 1*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	return db.Close()
+}
+
+// openDB opens a connection pool to the database, retrying every second until the
+// database accepts connections or the timeout passes.
+func openDB(driver, dsn string, timeout time.Duration) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		interval = time.NewTicker(1 * time.Second)
+		deadline = time.NewTimer(timeout)
+	)
+	defer interval.Stop()
+	defer deadline.Stop()
+
+	for {
+		// sql server's driver pings with a query of its own, which Queries can't tell apart
+		// from the caller's, so the database is checked with a marked query instead
+		_, err = db.Exec(internalSQL("SELECT 1"))
+		if err == nil {
+			return db, nil
+		}
+
+		select {
+		case <-deadline.C:
+			db.Close()
+
+			return nil, fmt.Errorf("timed out waiting for the database to accept connections, the last error was: %s", err)
+		case <-interval.C:
+		}
+	}
+}
+
+// sqlPool is the connection pool a container owns, opened the first time it is needed and
+// closed when the container is removed.
+type sqlPool struct {
+	mu sync.Mutex
+	db *sql.DB
+}
+
+// get returns the pool, opening it if it isn't open yet.
+func (p *sqlPool) get(d sqlDialect, dsn string) (*sql.DB, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db != nil {
+		return p.db, nil
+	}
+
+	db, err := openDB(d.driver, dsn, 1*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	p.db = db
+
+	return db, nil
+}
+
+// close closes the pool if it is open.
+func (p *sqlPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db != nil {
+		p.db.Close()
+		p.db = nil
+	}
+}
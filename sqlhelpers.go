@@ -0,0 +1,174 @@
+package easycontainers
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TestingT is the part of *testing.T the assertions use to report failures.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// queryRows runs the query and returns the rows as maps of column name to value. Values
+// the driver returns as []byte, like text columns in mysql, are returned as strings.
+func queryRows(db *sql.DB, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []map[string]interface{}{}
+
+	for rows.Next() {
+		var (
+			values   = make([]interface{}, len(columns))
+			pointers = make([]interface{}, len(columns))
+		)
+
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+
+		for i, c := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[c] = string(b)
+			} else {
+				row[c] = values[i]
+			}
+		}
+
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
+
+// countRows counts the rows in the table matching where, which can be empty to count
+// all of them.
+func countRows(db *sql.DB, d sqlDialect, table, where string, args ...interface{}) (int, error) {
+	query := "SELECT COUNT(*) FROM " + d.quote(table)
+	if where != "" {
+		query += " WHERE " + where
+	}
+
+	var count int
+
+	err := db.QueryRow(internalSQL(query), args...).Scan(&count)
+
+	return count, err
+}
+
+// assertRows checks that the rows in the table are the expected ones, in any order, and
+// reports the missing and unexpected rows to t if they aren't.
+//
+// Only the columns in the expected rows are compared, and a column one expected row has but
+// another doesn't is expected to be NULL in the second. Values are compared by how they
+// print, so 1 matches a bigint column and "2017-06-11" a date column read as a string.
+func assertRows(t TestingT, db *sql.DB, d sqlDialect, table string, expected []map[string]interface{}) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	actual, err := queryRows(db, internalSQL("SELECT * FROM "+d.quote(table)))
+	if err != nil {
+		t.Errorf("reading the rows of %s: %s", table, err)
+
+		return false
+	}
+
+	columnSet := map[string]bool{}
+	for _, row := range expected {
+		for c := range row {
+			columnSet[c] = true
+		}
+	}
+
+	columns := make([]string, 0, len(columnSet))
+	for c := range columnSet {
+		columns = append(columns, c)
+	}
+
+	sort.Strings(columns)
+
+	var (
+		missing    = rowStrings(expected, columns)
+		unexpected []string
+	)
+
+	for _, row := range rowStrings(actual, columns) {
+		i := sort.SearchStrings(missing, row)
+
+		if i < len(missing) && missing[i] == row {
+			missing = append(missing[:i], missing[i+1:]...)
+		} else {
+			unexpected = append(unexpected, row)
+		}
+	}
+
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return true
+	}
+
+	t.Errorf(
+		"the rows in %s don't match the expected rows\nmissing rows:\n%s\nunexpected rows:\n%s",
+		table,
+		indentRows(missing),
+		indentRows(unexpected),
+	)
+
+	return false
+}
+
+// rowStrings prints the columns of each row on a line, sorted.
+func rowStrings(rows []map[string]interface{}, columns []string) []string {
+	lines := make([]string, len(rows))
+
+	for i, row := range rows {
+		values := make([]string, len(columns))
+
+		for j, c := range columns {
+			values[j] = fmt.Sprintf("%s=%s", c, printValue(row[c]))
+		}
+
+		lines[i] = strings.Join(values, ", ")
+	}
+
+	sort.Strings(lines)
+
+	return lines
+}
+
+func printValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(x)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func indentRows(rows []string) string {
+	if len(rows) == 0 {
+		return "  (none)"
+	}
+
+	return "  " + strings.Join(rows, "\n  ")
+}
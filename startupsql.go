@@ -0,0 +1,222 @@
+package easycontainers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// StartupSQLError is returned when a statement in Path, Query or DumpPath fails while the
+// container starts.
+//
+// Source is the Path or DumpPath the failing statement is in, or "Query". Line is the line
+// the failing statement is on within Source, and Message is the error the server returned.
+// Statement is empty for compressed dumps.
+type StartupSQLError struct {
+	Source    string
+	Line      int
+	Statement string
+	Message   string
+}
+
+func (e *StartupSQLError) Error() string {
+	return fmt.Sprintf("startup sql failed at %s line %d: %s\n%s", e.Source, e.Line, e.Message, e.Statement)
+}
+
+// startupScript is the sql from Path and Query that runs when the container starts.
+//
+// file is the name the sql is copied into the entrypoint's startup script directory as, and
+// dumpFile the name DumpPath is copied in as, so failed can tell which script failed.
+type startupScript struct {
+	path    string
+	pathSQL string
+	query   string
+
+	file     string
+	dumpPath string
+	dumpFile string
+}
+
+// initScriptRunning matches the line the entrypoints log before running each startup
+// script, capturing the script's name
+var initScriptRunning = regexp.MustCompile(`running /docker-entrypoint-initdb\.d/(\S+)`)
+
+// readStartupSQL reads the sql file at sqlPath, relative to the GOPATH. Either sqlPath or
+// query can be empty.
+func readStartupSQL(sqlPath, query string) (*startupScript, error) {
+	s := &startupScript{
+		path:  sqlPath,
+		query: query,
+	}
+
+	if sqlPath != "" {
+		b, err := ioutil.ReadFile(path.Join(GoPath(), sqlPath))
+		if err != nil {
+			return nil, err
+		}
+
+		s.pathSQL = string(b)
+	}
+
+	return s, nil
+}
+
+// String returns the sql from Path followed by Query.
+func (s *startupScript) String() string {
+	if s.pathSQL == "" {
+		return s.query
+	}
+
+	if s.query == "" {
+		return s.pathSQL
+	}
+
+	// the semicolon is in case the sql from Path didn't end with a semicolon
+	return s.pathSQL + ";\n" + s.query
+}
+
+// failed turns the error from starting the container into a StartupSQLError, if the
+// container logs or the error contain a message from the server matching pattern and the
+// message is about the startup sql or the dump. Otherwise err is returned unchanged.
+func (s *startupScript) failed(err error, pattern *regexp.Regexp) error {
+	output := err.Error()
+	if died, ok := err.(*containerDiedError); ok {
+		output = died.Logs
+	}
+
+	loc := pattern.FindStringSubmatchIndex(output)
+	if loc == nil {
+		return err
+	}
+
+	line, convErr := strconv.Atoi(output[loc[2]:loc[3]])
+	if convErr != nil {
+		return err
+	}
+
+	message := strings.TrimSpace(output[loc[4]:loc[5]])
+
+	// the script that failed is the last one the entrypoint logged it was running
+	var script string
+	for _, m := range initScriptRunning.FindAllStringSubmatch(output[:loc[0]], -1) {
+		script = m[1]
+	}
+
+	if script != "" && script == s.dumpFile {
+		sqlErr := &StartupSQLError{
+			Source:  s.dumpPath,
+			Line:    line,
+			Message: message,
+		}
+
+		if !strings.HasSuffix(strings.ToLower(s.dumpPath), ".gz") {
+			dump, readErr := readDump(s.dumpPath)
+			if readErr == nil {
+				sqlErr.Statement = statementAt(string(dump), line)
+			}
+		}
+
+		return sqlErr
+	}
+
+	// another of the scripts in the directory, like the one creating Extensions, failed
+	if script != "" && script != s.file {
+		return err
+	}
+
+	sqlErr := &StartupSQLError{
+		Source:    "Query",
+		Line:      line,
+		Statement: statementAt(s.String(), line),
+		Message:   message,
+	}
+
+	if s.pathSQL != "" {
+		pathLines := strings.Count(s.pathSQL, "\n") + 1
+
+		if line <= pathLines {
+			sqlErr.Source = s.path
+		} else {
+			sqlErr.Line = line - pathLines
+		}
+	}
+
+	return sqlErr
+}
+
+// statementAt returns the statement in sql that is on the line, counting from 1. Statements
+// are split on semicolons that aren't in quotes or comments.
+func statementAt(sql string, line int) string {
+	var (
+		start     = 0
+		startLine = 1
+		curLine   = 1
+		quote     byte
+		comment   string
+	)
+
+	// statement returns the statement from start to end if it covers the line
+	statement := func(end int) (string, bool) {
+		stmt := sql[start:end]
+		trimmed := strings.TrimLeft(stmt, " \t\r\n")
+		firstLine := startLine + strings.Count(stmt[:len(stmt)-len(trimmed)], "\n")
+
+		if firstLine <= line && line <= curLine {
+			return strings.TrimSpace(stmt), true
+		}
+
+		return "", false
+	}
+
+	// all of the characters we look for are ascii, so walking the bytes is safe
+	for i := 0; i < len(sql); i++ {
+		var (
+			c    = sql[i]
+			next byte
+		)
+
+		if i+1 < len(sql) {
+			next = sql[i+1]
+		}
+
+		switch {
+		case c == '\n':
+			curLine++
+
+			if comment == "--" {
+				comment = ""
+			}
+		case comment == "/*":
+			if c == '*' && next == '/' {
+				comment = ""
+				i++
+			}
+		case comment != "":
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && next == '-':
+			comment = "--"
+		case c == '/' && next == '*':
+			comment = "/*"
+			i++
+		case c == ';':
+			if stmt, ok := statement(i); ok {
+				return stmt
+			}
+
+			start = i + 1
+			startLine = curLine
+		}
+	}
+
+	stmt, _ := statement(len(sql))
+
+	return stmt
+}
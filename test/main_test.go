@@ -0,0 +1,18 @@
+package test
+
+import (
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/tsmith-rv/easycontainers"
+)
+
+var update = flag.Bool("update", false, "update the golden files checked by AssertGolden")
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	easycontainers.UpdateGolden = *update
+
+	os.Exit(m.Run())
+}
@@ -0,0 +1,8 @@
+CREATE DATABASE blog;
+
+CREATE TABLE blog.authors (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  first_name varchar(50) NOT NULL,
+  last_name varchar(50) NOT NULL,
+  PRIMARY KEY (id)
+);
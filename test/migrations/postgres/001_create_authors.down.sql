@@ -0,0 +1 @@
+DROP SCHEMA blog CASCADE;
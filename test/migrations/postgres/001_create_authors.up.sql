@@ -0,0 +1,7 @@
+CREATE SCHEMA blog;
+
+CREATE TABLE blog.authors (
+  id SERIAL PRIMARY KEY,
+  first_name varchar(50) NOT NULL,
+  last_name varchar(50) NOT NULL
+);
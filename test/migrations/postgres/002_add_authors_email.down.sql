@@ -0,0 +1,3 @@
+DELETE FROM blog.authors WHERE email = 'zmcglynn@example.org';
+
+ALTER TABLE blog.authors DROP COLUMN email;
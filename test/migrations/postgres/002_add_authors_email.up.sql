@@ -0,0 +1,3 @@
+ALTER TABLE blog.authors ADD COLUMN email varchar(100) NULL;
+
+INSERT INTO blog.authors (first_name, last_name, email) VALUES ('Terrill', 'Buckridge', 'zmcglynn@example.org');
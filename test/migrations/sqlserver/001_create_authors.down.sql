@@ -0,0 +1 @@
+DROP TABLE blog.dbo.authors;
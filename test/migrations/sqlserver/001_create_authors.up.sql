@@ -0,0 +1,5 @@
+CREATE TABLE blog.dbo.authors (
+  id int NOT NULL IDENTITY PRIMARY KEY,
+  first_name varchar(50) NOT NULL,
+  last_name varchar(50) NOT NULL
+);
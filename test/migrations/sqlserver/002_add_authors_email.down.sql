@@ -0,0 +1,4 @@
+DELETE FROM blog.dbo.authors WHERE email = 'zmcglynn@example.org';
+GO
+
+ALTER TABLE blog.dbo.authors DROP COLUMN email;
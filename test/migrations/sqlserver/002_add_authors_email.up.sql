@@ -0,0 +1,5 @@
+-- the new column can only be used once the batch that adds it has run
+ALTER TABLE blog.dbo.authors ADD email varchar(100) NULL;
+GO
+
+INSERT INTO blog.dbo.authors (first_name, last_name, email) VALUES ('Terrill', 'Buckridge', 'zmcglynn@example.org');
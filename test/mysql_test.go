@@ -1,7 +1,12 @@
 package test
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"fmt"
 
@@ -151,3 +156,512 @@ func Test_MySQL_Container(t *testing.T) {
 		return
 	}
 }
+
+func Test_MySQL_Migrations(t *testing.T) {
+	container, _ := easycontainers.NewMySQL("Test_MySQL_Migrations")
+
+	container.Migrations = "/src/github.com/tsmith-rv/easycontainers/test/migrations/mysql"
+
+	err := container.Container(func() error {
+		db, err := sqlx.Connect("mysql", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var emails []string
+		err = db.Select(&emails, "SELECT email FROM blog.authors")
+		if err != nil {
+			return err
+		}
+
+		if !assert.Equal(t, []string{"zmcglynn@example.org"}, emails) {
+			return nil
+		}
+
+		// rolling back to version 1 should drop the email column again
+		err = container.MigrateDown(1)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec("SELECT email FROM blog.authors")
+		assert.Error(t, err)
+
+		return container.MigrateUp()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MySQL_Fixtures(t *testing.T) {
+	container, _ := easycontainers.NewMySQL("Test_MySQL_Fixtures")
+
+	container.Query = `
+		CREATE DATABASE blog;
+
+		CREATE TABLE blog.authors (
+		  id int NOT NULL,
+		  first_name varchar(50) NOT NULL,
+		  last_name varchar(50) NOT NULL,
+		  email varchar(100) NULL,
+		  PRIMARY KEY (id)
+		);
+
+		CREATE TABLE blog.posts (
+		  id int NOT NULL,
+		  author_id int NOT NULL,
+		  title varchar(255) NULL,
+		  PRIMARY KEY (id),
+		  FOREIGN KEY (author_id) REFERENCES blog.authors (id)
+		);
+	`
+
+	// posts are loaded before the authors they reference, which only works
+	// because foreign key checks are off while loading
+	container.AddFixtures(
+		easycontainers.Fixture{
+			Path:  "/src/github.com/tsmith-rv/easycontainers/test/fixtures/posts.csv",
+			Table: "blog.posts",
+		},
+		easycontainers.Fixture{
+			Path:  "/src/github.com/tsmith-rv/easycontainers/test/fixtures/authors.yml",
+			Table: "blog.authors",
+		},
+	)
+
+	err := container.Container(func() error {
+		db, err := sqlx.Connect("mysql", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var nullTitles int
+		err = db.Get(&nullTitles, "SELECT COUNT(*) FROM blog.posts WHERE title IS NULL")
+		if err != nil {
+			return err
+		}
+
+		if !assert.Equal(t, 1, nullTitles) {
+			return nil
+		}
+
+		_, err = db.Exec("DELETE FROM blog.posts")
+		if err != nil {
+			return err
+		}
+
+		err = container.ReloadFixtures()
+		if err != nil {
+			return err
+		}
+
+		var posts int
+		err = db.Get(&posts, "SELECT COUNT(*) FROM blog.posts")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 3, posts)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MySQL_NoReadinessArtifacts(t *testing.T) {
+	container, _ := easycontainers.NewMySQL("Test_MySQL_NoReadinessArtifacts")
+
+	container.Query = "CREATE DATABASE blog;"
+
+	err := container.Container(func() error {
+		db, err := sqlx.Connect("mysql", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var tables int
+		err = db.Get(&tables, "SELECT COUNT(*) FROM information_schema.tables WHERE table_name = 'z_z_'")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 0, tables, "readiness shouldn't leave tables behind")
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MySQL_StartupSQLError(t *testing.T) {
+	container, _ := easycontainers.NewMySQL("Test_MySQL_StartupSQLError")
+
+	container.Query = `CREATE DATABASE blog;
+		CREATE TABLE blog.authors (id int NOT NULL);
+		INSERT INTO blog.authors (id, name) VALUES (1, 'Terrill');`
+
+	err := container.Container(func() error {
+		t.Error("the callback shouldn't run when the startup sql fails")
+
+		return nil
+	})
+
+	sqlErr, ok := err.(*easycontainers.StartupSQLError)
+	if !assert.True(t, ok, "expected a StartupSQLError, got %v", err) {
+		return
+	}
+
+	assert.Equal(t, "Query", sqlErr.Source)
+	assert.Equal(t, 3, sqlErr.Line)
+	assert.Contains(t, sqlErr.Statement, "INSERT INTO blog.authors")
+	assert.Contains(t, sqlErr.Message, "Unknown column 'name'")
+}
+
+func Test_MySQL_Config(t *testing.T) {
+	container, _ := easycontainers.NewMySQL("Test_MySQL_Config")
+
+	container.Config = map[string]string{
+		"sql_mode":           "STRICT_ALL_TABLES,NO_ZERO_DATE",
+		"max_allowed_packet": "67108864",
+		"default-time-zone":  "'+02:00'",
+	}
+	container.ConfigFile = `
+		character-set-server=utf8mb4
+		collation-server=utf8mb4_unicode_ci
+	`
+
+	err := container.Container(func() error {
+		variables, err := container.Variables("sql_mode", "max_allowed_packet", "time_zone", "collation_server")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, map[string]string{
+			"sql_mode":           "STRICT_ALL_TABLES,NO_ZERO_DATE",
+			"max_allowed_packet": "67108864",
+			"time_zone":          "+02:00",
+			"collation_server":   "utf8mb4_unicode_ci",
+		}, variables)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MySQL_FastMode(t *testing.T) {
+	container, _ := easycontainers.NewMySQL("Test_MySQL_FastMode")
+
+	container.FastMode = true
+	container.TmpfsSize = "1g"
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/mysql-test.sql"
+
+	err := container.Container(func() error {
+		variables, err := container.Variables("innodb_flush_log_at_trx_commit", "sync_binlog")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, map[string]string{
+			"innodb_flush_log_at_trx_commit": "0",
+			"sync_binlog":                    "0",
+		}, variables)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MySQL_Replication(t *testing.T) {
+	container, _ := easycontainers.NewMySQL("Test_MySQL_Replication")
+
+	container.AddReplicas(2)
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/mysql-test.sql"
+
+	err := container.Container(func() error {
+		// the startup sql is replicated, so the replicas start with the authors
+		for _, dsn := range container.ReplicaDSNs() {
+			replica, err := sqlx.Connect("mysql", dsn)
+			if err != nil {
+				return err
+			}
+
+			var count int
+
+			err = replica.Get(&count, "SELECT COUNT(*) FROM blog.authors")
+			replica.Close()
+			if err != nil {
+				return err
+			}
+
+			assert.Equal(t, 5, count)
+		}
+
+		err := container.PauseReplication(0)
+		if err != nil {
+			return err
+		}
+
+		primary, err := sqlx.Connect("mysql", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer primary.Close()
+
+		_, err = primary.Exec("DELETE FROM blog.authors WHERE id = 5")
+		if err != nil {
+			return err
+		}
+
+		replica, err := sqlx.Connect("mysql", container.ReplicaDSNs()[0])
+		if err != nil {
+			return err
+		}
+		defer replica.Close()
+
+		var count int
+
+		err = replica.Get(&count, "SELECT COUNT(*) FROM blog.authors")
+		if err != nil {
+			return err
+		}
+
+		// the paused replica serves stale reads
+		assert.Equal(t, 5, count)
+
+		lag, err := container.ReplicationLag(0)
+		if err != nil {
+			return err
+		}
+
+		assert.True(t, lag > 0)
+
+		err = container.ResumeReplication(0)
+		if err != nil {
+			return err
+		}
+
+		err = container.WaitForReplicas()
+		if err != nil {
+			return err
+		}
+
+		err = replica.Get(&count, "SELECT COUNT(*) FROM blog.authors")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 4, count)
+
+		lag, err = container.ReplicationLag(0)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, time.Duration(0), lag)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MySQL_QueryLog(t *testing.T) {
+	container, _ := easycontainers.NewMySQL("Test_MySQL_QueryLog")
+
+	container.QueryLog = true
+
+	err := container.Container(func() error {
+		db, err := sqlx.Connect("mysql", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		_, err = db.Exec("SELECT 'before the step'")
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(1 * time.Second)
+		from := time.Now()
+
+		_, err = db.Exec("SELECT 'during the step'")
+		if err != nil {
+			return err
+		}
+
+		// neither the helpers' statements nor the healthchecks, which run every five
+		// seconds, are the test's own, so they are left out
+		_, err = container.Count("mysql.user", "")
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(6 * time.Second)
+
+		_, err = db.Exec("SELECT 'still during the step'")
+		if err != nil {
+			return err
+		}
+
+		entries, err := container.Queries(from, time.Now())
+		if err != nil {
+			return err
+		}
+
+		var statements []string
+		for _, e := range entries {
+			statements = append(statements, e.Statement)
+		}
+
+		assert.Equal(t, []string{"SELECT 'during the step'", "SELECT 'still during the step'"}, statements)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MySQL_Helpers(t *testing.T) {
+	container, _ := easycontainers.NewMySQL("Test_MySQL_Helpers")
+
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/mysql-test.sql"
+
+	err := container.Container(func() error {
+		_, err := container.Exec("DELETE FROM blog.authors WHERE id = ?", 5)
+		if err != nil {
+			return err
+		}
+
+		count, err := container.Count("blog.authors", "last_name = ?", "Buckridge")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 2, count)
+
+		rows, err := container.QueryRows("SELECT first_name FROM blog.authors WHERE id = ?", 1)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, []map[string]interface{}{{"first_name": "Terrill"}}, rows)
+
+		container.AssertRows(t, "blog.authors", []map[string]interface{}{
+			{"id": 4, "first_name": "Kristina"},
+			{"id": 3, "first_name": "Alivia"},
+			{"id": 2, "first_name": "Jamar"},
+			{"id": 1, "first_name": "Terrill"},
+		})
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MySQL_FailureDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "Test_MySQL_FailureDump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	container, _ := easycontainers.NewMySQL("Test_MySQL_FailureDump")
+
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/mysql-test.sql"
+	container.FailureDumpDir = dir
+
+	err = container.Container(func() error {
+		_, err := container.Exec("DELETE FROM blog.authors WHERE id = 5")
+		if err != nil {
+			return err
+		}
+
+		return errors.New("failing on purpose")
+	})
+	assert.EqualError(t, err, "failing on purpose")
+
+	dumps, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !assert.Len(t, dumps, 1) {
+		return
+	}
+
+	// DumpPath is relative to the GOPATH
+	dumpPath, err := filepath.Rel(easycontainers.GoPath(), dumps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, _ := easycontainers.NewMySQL("Test_MySQL_FailureDump_Restored")
+
+	restored.DumpPath = dumpPath
+
+	err = restored.Container(func() error {
+		count, err := restored.Count("blog.authors", "")
+		if err != nil {
+			return err
+		}
+
+		// the dump has the database as it was when the callback failed
+		assert.Equal(t, 4, count)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MySQL_Golden(t *testing.T) {
+	container, _ := easycontainers.NewMySQL("Test_MySQL_Golden")
+
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/mysql-test.sql"
+
+	err := container.Container(func() error {
+		_, err := container.Exec("DELETE FROM blog.authors WHERE id = 5")
+		if err != nil {
+			return err
+		}
+
+		container.AssertGolden(t, "testdata/authors.golden", "blog.authors")
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MySQL_AddReplicasError(t *testing.T) {
+	container, _ := easycontainers.NewMySQL("Test_MySQL_AddReplicasError")
+
+	container.AddReplicas(-1)
+
+	err := container.Container(func() error {
+		t.Error("the callback shouldn't run when the replicas can't be added")
+
+		return nil
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "AddReplicas needs a positive number of replicas, got -1")
+	}
+}
@@ -0,0 +1,3 @@
+CREATE SCHEMA blog;
+CREATE TABLE blog.authors (id integer PRIMARY KEY);
+INSERT INTO blog.missing (id) VALUES (1);
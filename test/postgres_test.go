@@ -1,7 +1,12 @@
 package test
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"fmt"
 
@@ -151,3 +156,529 @@ func Test_Postgres_Container(t *testing.T) {
 		return
 	}
 }
+
+func Test_Postgres_Migrations(t *testing.T) {
+	container, _ := easycontainers.NewPostgres("Test_Postgres_Migrations")
+
+	container.Migrations = "/src/github.com/tsmith-rv/easycontainers/test/migrations/postgres"
+
+	err := container.Container(func() error {
+		db, err := sqlx.Connect("postgres", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var emails []string
+		err = db.Select(&emails, "SELECT email FROM blog.authors")
+		if err != nil {
+			return err
+		}
+
+		if !assert.Equal(t, []string{"zmcglynn@example.org"}, emails) {
+			return nil
+		}
+
+		// rolling back to version 1 should drop the email column again
+		err = container.MigrateDown(1)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec("SELECT email FROM blog.authors")
+		assert.Error(t, err)
+
+		return container.MigrateUp()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgres_Fixtures(t *testing.T) {
+	container, _ := easycontainers.NewPostgres("Test_Postgres_Fixtures")
+
+	container.Query = `
+		CREATE SCHEMA blog;
+
+		CREATE TABLE blog.authors (
+		  id integer GENERATED BY DEFAULT AS IDENTITY,
+		  first_name varchar(50) NOT NULL,
+		  last_name varchar(50) NOT NULL,
+		  email varchar(100) NULL,
+		  PRIMARY KEY (id)
+		);
+
+		CREATE TABLE blog.posts (
+		  id integer NOT NULL,
+		  author_id integer NOT NULL REFERENCES blog.authors (id),
+		  title varchar(255) NULL,
+		  PRIMARY KEY (id)
+		);
+	`
+
+	// posts are loaded before the authors they reference, which only works
+	// because foreign key checks are off while loading
+	container.AddFixtures(
+		easycontainers.Fixture{
+			Path:  "/src/github.com/tsmith-rv/easycontainers/test/fixtures/posts.csv",
+			Table: "blog.posts",
+		},
+		easycontainers.Fixture{
+			Path:  "/src/github.com/tsmith-rv/easycontainers/test/fixtures/authors.yml",
+			Table: "blog.authors",
+		},
+	)
+
+	err := container.Container(func() error {
+		posts, err := container.Count("blog.posts", "")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 3, posts)
+
+		authors, err := container.Count("blog.authors", "")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 2, authors)
+
+		// the checks are back on once the fixtures are loaded
+		_, err = container.Exec("INSERT INTO blog.posts (id, author_id) VALUES (4, 99)")
+		assert.Error(t, err)
+
+		err = container.ReloadFixtures()
+		if err != nil {
+			return err
+		}
+
+		posts, err = container.Count("blog.posts", "")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 3, posts)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgres_ReadyWithoutStartupSQL(t *testing.T) {
+	container, _ := easycontainers.NewPostgres("Test_Postgres_ReadyWithoutStartupSQL")
+
+	err := container.Container(func() error {
+		// no Path or Query is set, but the first connection should still succeed
+		db, err := sqlx.Connect("postgres", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var one int
+		err = db.Get(&one, "SELECT 1")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 1, one)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgres_ConfigAndExtensions(t *testing.T) {
+	container, _ := easycontainers.NewPostgres("Test_Postgres_ConfigAndExtensions")
+
+	container.Config = map[string]string{
+		"max_connections": "50",
+		"log_statement":   "all",
+	}
+	container.Extensions = []string{"pg_trgm", "uuid-ossp"}
+
+	err := container.Container(func() error {
+		settings, err := container.Settings("max_connections", "log_statement")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, map[string]string{
+			"max_connections": "50",
+			"log_statement":   "all",
+		}, settings)
+
+		db, err := sqlx.Connect("postgres", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var extensions []string
+		err = db.Select(&extensions, "SELECT extname FROM pg_extension WHERE extname <> 'plpgsql' ORDER BY extname")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, []string{"pg_trgm", "uuid-ossp"}, extensions)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgres_PostGIS(t *testing.T) {
+	container, _ := easycontainers.NewPostgres("Test_Postgres_PostGIS")
+
+	container.Variant = easycontainers.PostgresVariantPostGIS
+
+	err := container.Container(func() error {
+		db, err := sqlx.Connect("postgres", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var distance float64
+		err = db.Get(&distance, "SELECT ST_Distance(ST_MakePoint(0, 0), ST_MakePoint(3, 4))")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 5.0, distance)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgres_FastMode(t *testing.T) {
+	container, _ := easycontainers.NewPostgres("Test_Postgres_FastMode")
+
+	container.FastMode = true
+	container.TmpfsSize = "1g"
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/postgres-test.sql"
+
+	err := container.Container(func() error {
+		settings, err := container.Settings("fsync", "synchronous_commit", "full_page_writes")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, map[string]string{
+			"fsync":              "off",
+			"synchronous_commit": "off",
+			"full_page_writes":   "off",
+		}, settings)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgres_Replication(t *testing.T) {
+	container, _ := easycontainers.NewPostgres("Test_Postgres_Replication")
+
+	container.AddReplicas(2)
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/postgres-test.sql"
+
+	err := container.Container(func() error {
+		// the startup sql is replicated, so the replicas start with the authors
+		for _, dsn := range container.ReplicaDSNs() {
+			replica, err := sqlx.Connect("postgres", dsn)
+			if err != nil {
+				return err
+			}
+
+			var count int
+
+			err = replica.Get(&count, "SELECT COUNT(*) FROM blog.authors")
+			replica.Close()
+			if err != nil {
+				return err
+			}
+
+			assert.Equal(t, 5, count)
+		}
+
+		err := container.PauseReplication(0)
+		if err != nil {
+			return err
+		}
+
+		primary, err := sqlx.Connect("postgres", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer primary.Close()
+
+		_, err = primary.Exec("DELETE FROM blog.authors WHERE id = 5")
+		if err != nil {
+			return err
+		}
+
+		replica, err := sqlx.Connect("postgres", container.ReplicaDSNs()[0])
+		if err != nil {
+			return err
+		}
+		defer replica.Close()
+
+		var count int
+
+		err = replica.Get(&count, "SELECT COUNT(*) FROM blog.authors")
+		if err != nil {
+			return err
+		}
+
+		// the paused replica serves stale reads
+		assert.Equal(t, 5, count)
+
+		lag, err := container.ReplicationLag(0)
+		if err != nil {
+			return err
+		}
+
+		assert.True(t, lag > 0)
+
+		err = container.ResumeReplication(0)
+		if err != nil {
+			return err
+		}
+
+		err = container.WaitForReplicas()
+		if err != nil {
+			return err
+		}
+
+		err = replica.Get(&count, "SELECT COUNT(*) FROM blog.authors")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 4, count)
+
+		lag, err = container.ReplicationLag(0)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, time.Duration(0), lag)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgres_QueryLog(t *testing.T) {
+	container, _ := easycontainers.NewPostgres("Test_Postgres_QueryLog")
+
+	container.QueryLog = true
+	// the window below only matches if the log timestamps don't depend on the time zone
+	container.Config = map[string]string{"log_timezone": "America/New_York"}
+
+	err := container.Container(func() error {
+		db, err := sqlx.Connect("postgres", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		_, err = db.Exec("SELECT 'before the step'")
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(1 * time.Second)
+		from := time.Now()
+
+		_, err = db.Exec("SELECT 'during the step'")
+		if err != nil {
+			return err
+		}
+
+		// neither the helpers' statements nor the healthchecks, which run every five
+		// seconds, are the test's own, so they are left out
+		_, err = container.Count("pg_catalog.pg_database", "")
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(6 * time.Second)
+
+		_, err = db.Exec("SELECT 'still during the step'")
+		if err != nil {
+			return err
+		}
+
+		entries, err := container.Queries(from, time.Now())
+		if err != nil {
+			return err
+		}
+
+		var statements []string
+		for _, e := range entries {
+			statements = append(statements, e.Statement)
+		}
+
+		assert.Equal(t, []string{"SELECT 'during the step'", "SELECT 'still during the step'"}, statements)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgres_Helpers(t *testing.T) {
+	container, _ := easycontainers.NewPostgres("Test_Postgres_Helpers")
+
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/postgres-test.sql"
+
+	err := container.Container(func() error {
+		_, err := container.Exec("DELETE FROM blog.authors WHERE id = $1", 5)
+		if err != nil {
+			return err
+		}
+
+		count, err := container.Count("blog.authors", "last_name = $1", "Buckridge")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 2, count)
+
+		rows, err := container.QueryRows("SELECT first_name FROM blog.authors WHERE id = $1", 1)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, []map[string]interface{}{{"first_name": "Terrill"}}, rows)
+
+		container.AssertRows(t, "blog.authors", []map[string]interface{}{
+			{"id": 4, "first_name": "Kristina"},
+			{"id": 3, "first_name": "Alivia"},
+			{"id": 2, "first_name": "Jamar"},
+			{"id": 1, "first_name": "Terrill"},
+		})
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgres_FailureDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "Test_Postgres_FailureDump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	container, _ := easycontainers.NewPostgres("Test_Postgres_FailureDump")
+
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/postgres-test.sql"
+	container.FailureDumpDir = dir
+
+	err = container.Container(func() error {
+		_, err := container.Exec("DELETE FROM blog.authors WHERE id = 5")
+		if err != nil {
+			return err
+		}
+
+		return errors.New("failing on purpose")
+	})
+	assert.EqualError(t, err, "failing on purpose")
+
+	dumps, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !assert.Len(t, dumps, 1) {
+		return
+	}
+
+	// DumpPath is relative to the GOPATH
+	dumpPath, err := filepath.Rel(easycontainers.GoPath(), dumps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, _ := easycontainers.NewPostgres("Test_Postgres_FailureDump_Restored")
+
+	restored.DumpPath = dumpPath
+
+	err = restored.Container(func() error {
+		count, err := restored.Count("blog.authors", "")
+		if err != nil {
+			return err
+		}
+
+		// the dump has the database as it was when the callback failed
+		assert.Equal(t, 4, count)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgres_DumpError(t *testing.T) {
+	container, _ := easycontainers.NewPostgres("Test_Postgres_DumpError")
+
+	container.DumpPath = "/src/github.com/tsmith-rv/easycontainers/test/postgres-broken-dump.sql"
+	container.Query = "INSERT INTO blog.authors (id) VALUES (1);"
+
+	err := container.Container(func() error {
+		t.Error("the callback shouldn't run when the dump fails")
+
+		return nil
+	})
+
+	sqlErr, ok := err.(*easycontainers.StartupSQLError)
+	if !assert.True(t, ok, "expected a StartupSQLError, got %v", err) {
+		return
+	}
+
+	// the dump runs before the startup sql, so the error is reported against the dump
+	assert.Equal(t, container.DumpPath, sqlErr.Source)
+	assert.Equal(t, 3, sqlErr.Line)
+	assert.Equal(t, "INSERT INTO blog.missing (id) VALUES (1)", sqlErr.Statement)
+	assert.Contains(t, sqlErr.Message, `relation "blog.missing" does not exist`)
+}
+
+func Test_Postgres_Golden(t *testing.T) {
+	container, _ := easycontainers.NewPostgres("Test_Postgres_Golden")
+
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/postgres-test.sql"
+
+	err := container.Container(func() error {
+		_, err := container.Exec("DELETE FROM blog.authors WHERE id = 5")
+		if err != nil {
+			return err
+		}
+
+		container.AssertGolden(t, "testdata/authors.golden", "blog.authors")
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,7 +1,11 @@
 package test
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tsmith-rv/easycontainers"
@@ -56,3 +60,510 @@ func Test_RabbitMQ_Container(t *testing.T) {
 		return
 	}
 }
+
+func Test_RabbitMQ_Users(t *testing.T) {
+	rabbitContainer, _ := easycontainers.NewRabbitMQ("Test_RabbitMQ_Users")
+
+	vhost := easycontainers.Vhost{
+		Name: "Import",
+	}
+
+	user := easycontainers.User{
+		Name:     "importer",
+		Password: "importerpass",
+		Tags:     []string{"management"},
+	}
+
+	rabbitContainer.
+		AddVhosts(vhost).
+		AddUsers(user).
+		AddPermissions(easycontainers.Permission{
+			User:      user,
+			Vhost:     &vhost,
+			Configure: "^$",
+			Write:     "^data_exchange$",
+			Read:      "^ha\\.data_exchange\\..*",
+		})
+
+	err := rabbitContainer.Container(func() error {
+		var permissions []map[string]string
+
+		err := getManagementAPI(rabbitContainer, "/api/users/importer/permissions", &permissions)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, []map[string]string{
+			{
+				"user":      "importer",
+				"vhost":     "Import",
+				"configure": "^$",
+				"write":     "^data_exchange$",
+				"read":      "^ha\\.data_exchange\\..*",
+			},
+		}, permissions)
+
+		// the user can log into the management API with its own password
+		req, err := http.NewRequest("GET", rabbitContainer.ManagementURL()+"/api/whoami", nil)
+		if err != nil {
+			return err
+		}
+
+		req.SetBasicAuth("importer", "importerpass")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_RabbitMQ_Topology(t *testing.T) {
+	rabbitContainer, _ := easycontainers.NewRabbitMQ("Test_RabbitMQ_Topology")
+
+	events := easycontainers.Exchange{
+		Name: "events",
+		Type: easycontainers.ExchangeTypeTopic,
+	}
+
+	documents := easycontainers.Exchange{
+		Name:       "documents",
+		Type:       easycontainers.ExchangeTypeHeaders,
+		Transient:  true,
+		AutoDelete: true,
+	}
+
+	deadLetters := easycontainers.Exchange{
+		Name: "dead_letters",
+		Type: easycontainers.ExchangeTypeFanout,
+	}
+
+	orders := easycontainers.Queue{
+		Name:               "orders",
+		Type:               easycontainers.QueueTypeQuorum,
+		MessageTTL:         1 * time.Minute,
+		MaxLength:          100,
+		DeadLetterExchange: deadLetters.Name,
+	}
+
+	pdfs := easycontainers.Queue{
+		Name: "pdfs",
+		Lazy: true,
+	}
+
+	rabbitContainer.
+		AddExchanges(events, documents, deadLetters).
+		AddQueue(orders, pdfs).
+		AddBinding(
+			easycontainers.QueueBinding{
+				Source:      events,
+				Destination: orders,
+				RoutingKey:  "order.*",
+			},
+			easycontainers.QueueBinding{
+				Source:      documents,
+				Destination: pdfs,
+				Arguments: map[string]interface{}{
+					"x-match": "all",
+					"format":  "pdf",
+				},
+			},
+		).
+		AddExchangeBinding(easycontainers.ExchangeBinding{
+			Source:      events,
+			Destination: documents,
+			RoutingKey:  "document.#",
+		})
+
+	err := rabbitContainer.Container(func() error {
+		var queue struct {
+			Durable   bool                   `json:"durable"`
+			Arguments map[string]interface{} `json:"arguments"`
+		}
+
+		err := getManagementAPI(rabbitContainer, "/api/queues/%2F/orders", &queue)
+		if err != nil {
+			return err
+		}
+
+		assert.True(t, queue.Durable)
+		assert.Equal(t, map[string]interface{}{
+			"x-queue-type":           "quorum",
+			"x-message-ttl":          float64(60000),
+			"x-max-length":           float64(100),
+			"x-dead-letter-exchange": "dead_letters",
+		}, queue.Arguments)
+
+		var exchange struct {
+			Durable    bool `json:"durable"`
+			AutoDelete bool `json:"auto_delete"`
+		}
+
+		err = getManagementAPI(rabbitContainer, "/api/exchanges/%2F/documents", &exchange)
+		if err != nil {
+			return err
+		}
+
+		assert.False(t, exchange.Durable)
+		assert.True(t, exchange.AutoDelete)
+
+		var bindings []struct {
+			Source     string `json:"source"`
+			RoutingKey string `json:"routing_key"`
+		}
+
+		err = getManagementAPI(rabbitContainer, "/api/exchanges/%2F/documents/bindings/destination", &bindings)
+		if err != nil {
+			return err
+		}
+
+		if assert.Len(t, bindings, 1) {
+			assert.Equal(t, "events", bindings[0].Source)
+			assert.Equal(t, "document.#", bindings[0].RoutingKey)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getManagementAPI gets the path from the container's management API as the guest user,
+// and decodes the JSON response into v.
+func getManagementAPI(r *easycontainers.RabbitMQ, path string, v interface{}) error {
+	req, err := http.NewRequest("GET", r.ManagementURL()+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth("guest", "guest")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func Test_RabbitMQ_Definitions(t *testing.T) {
+	rabbitContainer, _ := easycontainers.NewRabbitMQ("Test_RabbitMQ_Definitions")
+
+	rabbitContainer.DefinitionsPath = "/src/github.com/tsmith-rv/easycontainers/test/rabbitmq-definitions.json"
+
+	// the vhost comes from the definitions file
+	vhost := easycontainers.Vhost{
+		Name: "Billing",
+	}
+
+	rabbitContainer.AddQueue(easycontainers.Queue{
+		Name:    "invoices.paid",
+		Durable: true,
+		Vhost:   &vhost,
+	})
+
+	err := rabbitContainer.Container(func() error {
+		b, err := rabbitContainer.ExportDefinitions()
+		if err != nil {
+			return err
+		}
+
+		var definitions struct {
+			Queues []struct {
+				Name  string `json:"name"`
+				Vhost string `json:"vhost"`
+			} `json:"queues"`
+			Bindings []struct {
+				Source      string `json:"source"`
+				Destination string `json:"destination"`
+			} `json:"bindings"`
+		}
+
+		err = json.Unmarshal(b, &definitions)
+		if err != nil {
+			return err
+		}
+
+		var queues []string
+		for _, q := range definitions.Queues {
+			queues = append(queues, q.Vhost+"/"+q.Name)
+		}
+
+		assert.ElementsMatch(t, []string{"Billing/invoices.created", "Billing/invoices.paid"}, queues)
+
+		if assert.Len(t, definitions.Bindings, 1) {
+			assert.Equal(t, "invoices", definitions.Bindings[0].Source)
+			assert.Equal(t, "invoices.created", definitions.Bindings[0].Destination)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_RabbitMQ_Messages(t *testing.T) {
+	rabbitContainer, _ := easycontainers.NewRabbitMQ("Test_RabbitMQ_Messages")
+
+	vhost := easycontainers.Vhost{
+		Name: "Orders",
+	}
+
+	events := easycontainers.Exchange{
+		Name:  "events",
+		Type:  easycontainers.ExchangeTypeTopic,
+		Vhost: &vhost,
+	}
+
+	orders := easycontainers.Queue{
+		Name:    "orders",
+		Durable: true,
+		Vhost:   &vhost,
+	}
+
+	rabbitContainer.
+		AddVhosts(vhost).
+		AddExchanges(events).
+		AddQueue(orders).
+		AddBinding(easycontainers.QueueBinding{
+			Source:      events,
+			Destination: orders,
+			RoutingKey:  "order.*",
+			Vhost:       &vhost,
+		})
+
+	err := rabbitContainer.Container(func() error {
+		tap, err := rabbitContainer.Tap(&vhost, "events", "#")
+		if err != nil {
+			return err
+		}
+		defer tap.Close()
+
+		routed, err := rabbitContainer.Publish(&vhost, "events", "order.created", easycontainers.Message{
+			Body:        []byte(`{"id":1}`),
+			ContentType: "application/json",
+			Headers:     map[string]interface{}{"source": "checkout"},
+			Persistent:  true,
+		})
+		if err != nil {
+			return err
+		}
+
+		assert.True(t, routed)
+
+		// only the tap gets this one
+		_, err = rabbitContainer.Publish(&vhost, "events", "user.created", easycontainers.Message{
+			Body: []byte(`{"id":2}`),
+		})
+		if err != nil {
+			return err
+		}
+
+		messages, err := rabbitContainer.ConsumeMessages(&vhost, "orders", 1, 5*time.Second)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, `{"id":1}`, string(messages[0].Body))
+		assert.Equal(t, "application/json", messages[0].ContentType)
+		assert.Equal(t, map[string]interface{}{"source": "checkout"}, messages[0].Headers)
+		assert.True(t, messages[0].Persistent)
+		assert.Equal(t, "order.created", messages[0].RoutingKey)
+
+		published, err := tap.Messages(2, 5*time.Second)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, "order.created", published[0].RoutingKey)
+		assert.Equal(t, "user.created", published[1].RoutingKey)
+
+		// the queue is empty now
+		messages, err = rabbitContainer.GetMessages(&vhost, "orders", 10)
+		if err != nil {
+			return err
+		}
+
+		assert.Empty(t, messages)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_RabbitMQ_Plugins(t *testing.T) {
+	rabbitContainer, _ := easycontainers.NewRabbitMQ("Test_RabbitMQ_Plugins")
+
+	rabbitContainer.
+		AddPlugins(easycontainers.PluginShovel, easycontainers.PluginConsistentHash).
+		AddExchanges(easycontainers.Exchange{
+			Name: "partitions",
+			Type: easycontainers.ExchangeTypeConsistentHash,
+		})
+
+	err := rabbitContainer.Container(func() error {
+		var exchange struct {
+			Type string `json:"type"`
+		}
+
+		err := getManagementAPI(rabbitContainer, "/api/exchanges/%2F/partitions", &exchange)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, easycontainers.ExchangeTypeConsistentHash, exchange.Type)
+
+		var overview struct {
+			ExchangeTypes []struct {
+				Name string `json:"name"`
+			} `json:"exchange_types"`
+		}
+
+		err = getManagementAPI(rabbitContainer, "/api/overview", &overview)
+		if err != nil {
+			return err
+		}
+
+		var types []string
+		for _, e := range overview.ExchangeTypes {
+			types = append(types, e.Name)
+		}
+
+		assert.Contains(t, types, easycontainers.ExchangeTypeConsistentHash)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_RabbitMQ_Cluster(t *testing.T) {
+	rabbitContainer, _ := easycontainers.NewRabbitMQ("Test_RabbitMQ_Cluster")
+
+	rabbitContainer.
+		AddNodes(2).
+		AddQueue(easycontainers.Queue{
+			Name: "orders",
+			Type: easycontainers.QueueTypeQuorum,
+		})
+
+	err := rabbitContainer.Container(func() error {
+		assert.Len(t, rabbitContainer.NodeAddrs(), 3)
+
+		var nodes []struct {
+			Name    string `json:"name"`
+			Running bool   `json:"running"`
+		}
+
+		err := getManagementAPI(rabbitContainer, "/api/nodes", &nodes)
+		if err != nil {
+			return err
+		}
+
+		assert.Len(t, nodes, 3)
+
+		for _, n := range nodes {
+			assert.True(t, n.Running, "node %s isn't running", n.Name)
+		}
+
+		// the quorum queue keeps working while a minority of the nodes are down
+		err = rabbitContainer.StopNode(2)
+		if err != nil {
+			return err
+		}
+
+		routed, err := rabbitContainer.Publish(nil, "", "orders", easycontainers.Message{
+			Body: []byte("while a node is down"),
+		})
+		if err != nil {
+			return err
+		}
+
+		assert.True(t, routed)
+
+		err = rabbitContainer.StartNode(2)
+		if err != nil {
+			return err
+		}
+
+		err = rabbitContainer.RestartNode(1)
+		if err != nil {
+			return err
+		}
+
+		messages, err := rabbitContainer.ConsumeMessages(nil, "orders", 1, 10*time.Second)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, "while a node is down", string(messages[0].Body))
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_RabbitMQ_ProvisionError(t *testing.T) {
+	rabbitContainer, _ := easycontainers.NewRabbitMQ("Test_RabbitMQ_ProvisionError")
+
+	events := easycontainers.Exchange{
+		Name: "events",
+		Type: easycontainers.ExchangeTypeTopic,
+	}
+
+	rabbitContainer.
+		AddExchanges(events).
+		AddQueue(easycontainers.Queue{
+			Name: "orders",
+		}).
+		// the destination queue is never declared
+		AddBinding(easycontainers.QueueBinding{
+			Source:      events,
+			Destination: easycontainers.Queue{Name: "missing"},
+			RoutingKey:  "order.*",
+		})
+
+	err := rabbitContainer.Container(func() error {
+		t.Error("the callback shouldn't run when the topology can't be created")
+
+		return nil
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the binding from exchange events to queue missing in vhost /")
+	}
+}
+
+func Test_RabbitMQ_AddNodesError(t *testing.T) {
+	rabbitContainer, _ := easycontainers.NewRabbitMQ("Test_RabbitMQ_AddNodesError")
+
+	rabbitContainer.AddNodes(0)
+
+	err := rabbitContainer.Container(func() error {
+		t.Error("the callback shouldn't run when the nodes can't be added")
+
+		return nil
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "AddNodes needs a positive number of nodes, got 0")
+	}
+}
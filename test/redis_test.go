@@ -0,0 +1,272 @@
+package test
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tsmith-rv/easycontainers"
+)
+
+func Test_Redis_Container(t *testing.T) {
+	container, _ := easycontainers.NewRedis("Test_Redis_Container")
+
+	container.Password = "secret pass"
+	container.MaxMemory = "10mb"
+	container.MaxMemoryPolicy = "allkeys-lru"
+	container.AppendOnly = true
+	container.Config = map[string]string{
+		"notify-keyspace-events": "Ex",
+	}
+	container.AddUsers(easycontainers.RedisUser{
+		Name:     "cache",
+		Password: "cachepass",
+		Rules:    []string{"~cache:*", "+@read"},
+	})
+
+	err := container.Container(func() error {
+		// the callback only runs once redis is ready, so the first command succeeds
+		reply, err := container.Do("SET", "greeting", "hello")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, "OK", reply)
+
+		for setting, expected := range map[string]string{
+			"maxmemory":              "10485760",
+			"maxmemory-policy":       "allkeys-lru",
+			"appendonly":             "yes",
+			"notify-keyspace-events": "xE",
+		} {
+			reply, err = container.Do("CONFIG", "GET", setting)
+			if err != nil {
+				return err
+			}
+
+			assert.Equal(t, []interface{}{setting, expected}, reply)
+		}
+
+		reply, err = container.Do("ACL", "USERS")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, []interface{}{"cache", "default"}, reply)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Redis_Seed(t *testing.T) {
+	container, _ := easycontainers.NewRedis("Test_Redis_Seed")
+
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/redis-test.txt"
+	container.
+		AddSeed("session:1", "terrill", 1*time.Hour).
+		AddSeed("recent", []string{"1", "2", "3"}, 0)
+
+	err := container.Container(func() error {
+		reply, err := container.Do("HGET", "author:2", "first_name")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, "Jamar", reply)
+
+		reply, err = container.Do("TTL", "session:1")
+		if err != nil {
+			return err
+		}
+
+		// the commands file ends in database 1, which doesn't carry over to the seed keys
+		assert.True(t, reply.(int64) > 0)
+
+		// change the seeded data, then reset it
+		_, err = container.Do("DEL", "author:1")
+		if err != nil {
+			return err
+		}
+
+		_, err = container.Do("SET", "greeting", "changed")
+		if err != nil {
+			return err
+		}
+
+		_, err = container.Do("SET", "added", "by the test")
+		if err != nil {
+			return err
+		}
+
+		err = container.Reset()
+		if err != nil {
+			return err
+		}
+
+		reply, err = container.Do("DBSIZE")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, int64(6), reply)
+
+		reply, err = container.Do("GET", "greeting")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, "hello world", reply)
+
+		reply, err = container.Do("LRANGE", "recent", "0", "-1")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, []interface{}{"1", "2", "3"}, reply)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Redis_Cluster(t *testing.T) {
+	container, _ := easycontainers.NewRedisCluster("Test_Redis_Cluster", 3, 1)
+
+	container.Password = "pass"
+
+	err := container.Container(func() error {
+		reply, err := container.Do(container.Ports[0], "CLUSTER", "INFO")
+		if err != nil {
+			return err
+		}
+
+		assert.Contains(t, reply, "cluster_slots_assigned:16384")
+
+		masters, err := container.MasterPorts()
+		if err != nil {
+			return err
+		}
+
+		assert.Len(t, masters, 3)
+
+		promoted, err := container.Failover(masters[0])
+		if err != nil {
+			return err
+		}
+
+		after, err := container.MasterPorts()
+		if err != nil {
+			return err
+		}
+
+		assert.Contains(t, after, promoted)
+		assert.NotContains(t, after, masters[0])
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Redis_Sentinel(t *testing.T) {
+	container, _ := easycontainers.NewRedisSentinel("Test_Redis_Sentinel", 2, 3)
+
+	container.Password = "pass"
+
+	err := container.Container(func() error {
+		master, err := container.MasterAddr()
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", container.Port), master)
+
+		_, err = container.Do(container.Port, "SET", "greeting", "hello")
+		if err != nil {
+			return err
+		}
+
+		promoted, err := container.Failover()
+		if err != nil {
+			return err
+		}
+
+		assert.NotEqual(t, master, promoted)
+
+		// the data written to the old master was replicated to the new one
+		port, err := strconv.Atoi(promoted[strings.LastIndex(promoted, ":")+1:])
+		if err != nil {
+			return err
+		}
+
+		reply, err := container.Do(port, "GET", "greeting")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, "hello", reply)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Redis_Stack(t *testing.T) {
+	container, _ := easycontainers.NewRedis("Test_Redis_Stack")
+
+	container.Variant = easycontainers.RedisVariantStack
+	container.
+		AddSearchIndexes(easycontainers.RedisSearchIndex{
+			Name:     "authors",
+			On:       "JSON",
+			Prefixes: []string{"author:"},
+			Schema:   []string{"$.first_name", "AS", "first_name", "TEXT", "$.age", "AS", "age", "NUMERIC"},
+		}).
+		AddJSONDocument("author:1", map[string]interface{}{"first_name": "Terrill", "age": 41}).
+		AddJSONDocument("author:2", map[string]interface{}{"first_name": "Jamar", "age": 29})
+
+	err := container.Container(func() error {
+		reply, err := container.Do("JSON.GET", "author:1", "$.first_name")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, `["Terrill"]`, reply)
+
+		reply, err = container.Do("FT.SEARCH", "authors", "@age:[30 50]", "NOCONTENT")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, []interface{}{int64(1), "author:1"}, reply)
+
+		// the index is created again on reset, and indexes the restored documents
+		err = container.Reset()
+		if err != nil {
+			return err
+		}
+
+		reply, err = container.Do("FT.SEARCH", "authors", "@first_name:Jamar", "NOCONTENT")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, []interface{}{int64(1), "author:2"}, reply)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,8 +1,13 @@
 package test
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	_ "github.com/denisenkom/go-mssqldb"
 	"github.com/jmoiron/sqlx"
@@ -150,3 +155,498 @@ func Test_SqlServer_Container(t *testing.T) {
 		return
 	}
 }
+
+func Test_SqlServer_Migrations(t *testing.T) {
+	container, _ := easycontainers.NewSQLServer("Test_SqlServer_Migrations")
+
+	// migrations run in a transaction, which CREATE DATABASE can't
+	container.AddDatabases(easycontainers.SQLServerDatabase{Name: "blog"})
+
+	container.Migrations = "/src/github.com/tsmith-rv/easycontainers/test/migrations/sqlserver"
+
+	err := container.Container(func() error {
+		db, err := sqlx.Connect("sqlserver", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var versions []int
+		err = db.Select(&versions, "SELECT version FROM master.dbo.schema_migrations ORDER BY version")
+		if err != nil {
+			return err
+		}
+
+		if !assert.Equal(t, []int{1, 2}, versions) {
+			return nil
+		}
+
+		var emails []string
+		err = db.Select(&emails, "SELECT email FROM blog.dbo.authors")
+		if err != nil {
+			return err
+		}
+
+		if !assert.Equal(t, []string{"zmcglynn@example.org"}, emails) {
+			return nil
+		}
+
+		// rolling back to version 1 should drop the email column again
+		err = container.MigrateDown(1)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec("SELECT email FROM blog.dbo.authors")
+		assert.Error(t, err)
+
+		versions = nil
+		err = db.Select(&versions, "SELECT version FROM master.dbo.schema_migrations ORDER BY version")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, []int{1}, versions)
+
+		return container.MigrateUp()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SqlServer_Batches(t *testing.T) {
+	container, _ := easycontainers.NewSQLServer("Test_SqlServer_Batches")
+
+	container.AddDatabases(
+		easycontainers.SQLServerDatabase{Name: "blog", Collation: "Latin1_General_CS_AS"},
+		easycontainers.SQLServerDatabase{Name: "shop"},
+	)
+
+	container.Query = `
+		USE blog
+		GO
+
+		CREATE TABLE authors (id int NOT NULL IDENTITY, name varchar(50) NOT NULL)
+		GO
+
+		-- a procedure has to be the only statement in its batch
+		CREATE PROCEDURE add_author @name varchar(50) AS
+			INSERT INTO authors (name) VALUES (@name)
+		GO
+
+		EXEC add_author 'Terrill'
+		GO 3
+
+		USE shop
+		GO
+
+		CREATE TABLE products (id int NOT NULL)
+		GO
+	`
+
+	err := container.Container(func() error {
+		db, err := sqlx.Connect("sqlserver", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var count int
+
+		err = db.Get(&count, "SELECT COUNT(*) FROM blog.dbo.authors")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 3, count)
+
+		err = db.Get(&count, "SELECT COUNT(*) FROM shop.dbo.products")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 0, count)
+
+		var collation string
+
+		err = db.Get(&collation, "SELECT CAST(DATABASEPROPERTYEX('blog', 'Collation') AS varchar(128))")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, "Latin1_General_CS_AS", collation)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SqlServer_Fixtures(t *testing.T) {
+	container, _ := easycontainers.NewSQLServer("Test_SqlServer_Fixtures")
+
+	container.AddDatabases(easycontainers.SQLServerDatabase{Name: "blog"})
+
+	container.Query = `
+		USE blog
+		GO
+
+		CREATE TABLE authors (
+		  id int NOT NULL IDENTITY,
+		  first_name varchar(50) NOT NULL,
+		  last_name varchar(50) NOT NULL,
+		  email varchar(100) NULL,
+		  PRIMARY KEY (id)
+		)
+
+		CREATE TABLE posts (
+		  id int NOT NULL,
+		  author_id int NOT NULL FOREIGN KEY REFERENCES authors (id),
+		  title varchar(255) NULL,
+		  PRIMARY KEY (id)
+		)
+		GO
+	`
+
+	// posts are loaded before the authors they reference, which only works
+	// because the constraints are off while loading, and the authors' ids are
+	// only kept because of IDENTITY_INSERT
+	container.AddFixtures(
+		easycontainers.Fixture{
+			Path:  "/src/github.com/tsmith-rv/easycontainers/test/fixtures/posts.csv",
+			Table: "blog.dbo.posts",
+		},
+		easycontainers.Fixture{
+			Path:  "/src/github.com/tsmith-rv/easycontainers/test/fixtures/authors.yml",
+			Table: "blog.dbo.authors",
+		},
+	)
+
+	err := container.Container(func() error {
+		container.AssertRows(t, "blog.dbo.authors", []map[string]interface{}{
+			{"id": 1, "first_name": "Terrill"},
+			{"id": 2, "first_name": "Jamar"},
+		})
+
+		posts, err := container.Count("blog.dbo.posts", "")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 3, posts)
+
+		// the constraints are checked again once the fixtures are loaded
+		_, err = container.Exec("INSERT INTO blog.dbo.posts (id, author_id) VALUES (4, 99)")
+		assert.Error(t, err)
+
+		// and IDENTITY_INSERT is off, so the identity picks up after the loaded ids
+		_, err = container.Exec("INSERT INTO blog.dbo.authors (first_name, last_name) VALUES ('Alivia', 'McLaughlin')")
+		if err != nil {
+			return err
+		}
+
+		count, err := container.Count("blog.dbo.authors", "id = 3")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 1, count)
+
+		err = container.ReloadFixtures()
+		if err != nil {
+			return err
+		}
+
+		count, err = container.Count("blog.dbo.authors", "")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 2, count)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SqlServer_FixturesGeneratedIds(t *testing.T) {
+	container, _ := easycontainers.NewSQLServer("Test_SqlServer_FixturesGeneratedIds")
+
+	container.AddDatabases(easycontainers.SQLServerDatabase{Name: "blog"})
+
+	container.Query = `
+		USE blog
+		GO
+
+		CREATE TABLE authors (
+		  id int NOT NULL IDENTITY,
+		  first_name varchar(50) NOT NULL,
+		  last_name varchar(50) NOT NULL,
+		  email varchar(100) NULL,
+		  PRIMARY KEY (id)
+		)
+		GO
+	`
+
+	// the fixture leaves out the identity column, so IDENTITY_INSERT has to stay
+	// off for the ids to be generated
+	container.AddFixtures(easycontainers.Fixture{
+		Path:  "/src/github.com/tsmith-rv/easycontainers/test/fixtures/authors-generated-ids.yml",
+		Table: "blog.dbo.authors",
+	})
+
+	err := container.Container(func() error {
+		container.AssertRows(t, "blog.dbo.authors", []map[string]interface{}{
+			{"id": 1, "first_name": "Alivia"},
+			{"id": 2, "first_name": "Emmalee"},
+		})
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SqlServer_BatchError(t *testing.T) {
+	container, _ := easycontainers.NewSQLServer("Test_SqlServer_BatchError")
+
+	container.Query = `CREATE DATABASE blog
+GO
+USE blog
+CREATE TABLE authors (id int NOT NULL)
+GO
+INSERT INTO authors (id) VALUES (1)
+INSERT INTO authors (id, name) VALUES (2, 'Terrill')
+GO`
+
+	err := container.Container(func() error {
+		t.Error("the callback shouldn't run when the startup sql fails")
+
+		return nil
+	})
+
+	sqlErr, ok := err.(*easycontainers.StartupSQLError)
+	if !assert.True(t, ok, "expected a StartupSQLError, got %v", err) {
+		return
+	}
+
+	assert.Equal(t, "Query", sqlErr.Source)
+	assert.Equal(t, 7, sqlErr.Line)
+	assert.Contains(t, sqlErr.Statement, "INSERT INTO authors (id) VALUES (1)")
+	assert.Contains(t, sqlErr.Message, "Invalid column name 'name'")
+}
+
+func Test_SqlServer_QueryLog(t *testing.T) {
+	container, _ := easycontainers.NewSQLServer("Test_SqlServer_QueryLog")
+
+	container.QueryLog = true
+
+	err := container.Container(func() error {
+		db, err := sqlx.Connect("sqlserver", container.DSN())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		_, err = db.Exec("SELECT 'before the step'")
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(1 * time.Second)
+		from := time.Now()
+
+		_, err = db.Exec("SELECT 'during the step'")
+		if err != nil {
+			return err
+		}
+
+		// neither the helpers' statements nor the healthchecks, which run every five
+		// seconds, are the test's own, so they are left out
+		_, err = container.Count("sys.databases", "")
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(6 * time.Second)
+
+		_, err = db.Exec("SELECT 'still during the step'")
+		if err != nil {
+			return err
+		}
+
+		// the events reach the ring buffer up to a second after they happen
+		time.Sleep(2 * time.Second)
+
+		entries, err := container.Queries(from, time.Now())
+		if err != nil {
+			return err
+		}
+
+		var statements []string
+		for _, e := range entries {
+			statements = append(statements, e.Statement)
+		}
+
+		assert.Equal(t, []string{"SELECT 'during the step'", "SELECT 'still during the step'"}, statements)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SqlServer_Helpers(t *testing.T) {
+	container, _ := easycontainers.NewSQLServer("Test_SqlServer_Helpers")
+
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/sqlserver-test.sql"
+
+	err := container.Container(func() error {
+		_, err := container.Exec("DELETE FROM blog.authors WHERE id = @p1", 5)
+		if err != nil {
+			return err
+		}
+
+		count, err := container.Count("blog.authors", "last_name = @p1", "Buckridge")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 2, count)
+
+		rows, err := container.QueryRows("SELECT first_name FROM blog.authors WHERE id = @p1", 1)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, []map[string]interface{}{{"first_name": "Terrill"}}, rows)
+
+		container.AssertRows(t, "blog.authors", []map[string]interface{}{
+			{"id": 4, "first_name": "Kristina"},
+			{"id": 3, "first_name": "Alivia"},
+			{"id": 2, "first_name": "Jamar"},
+			{"id": 1, "first_name": "Terrill"},
+		})
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SqlServer_FailureDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "Test_SqlServer_FailureDump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	container, _ := easycontainers.NewSQLServer("Test_SqlServer_FailureDump")
+
+	// only user databases are backed up
+	container.AddDatabases(easycontainers.SQLServerDatabase{Name: "blog"})
+
+	container.Query = `
+		CREATE TABLE blog.dbo.authors (id int NOT NULL PRIMARY KEY, first_name varchar(50) NOT NULL)
+		GO
+
+		INSERT INTO blog.dbo.authors (id, first_name) VALUES (1, 'Terrill'), (2, 'Jamar'), (3, 'Alivia')
+		GO
+	`
+	container.FailureDumpDir = dir
+
+	err = container.Container(func() error {
+		_, err := container.Exec("DELETE FROM blog.dbo.authors WHERE id = 3")
+		if err != nil {
+			return err
+		}
+
+		return errors.New("failing on purpose")
+	})
+	assert.EqualError(t, err, "failing on purpose")
+
+	dumps, err := filepath.Glob(filepath.Join(dir, "*.bak"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !assert.Len(t, dumps, 1) {
+		return
+	}
+
+	// DumpPath is relative to the GOPATH
+	dumpPath, err := filepath.Rel(easycontainers.GoPath(), dumps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, _ := easycontainers.NewSQLServer("Test_SqlServer_FailureDump_Restored")
+
+	restored.DumpPath = dumpPath
+	restored.FastMode = true
+
+	err = restored.Container(func() error {
+		// the backup has the database as it was when the callback failed, and its files
+		// were moved to where this server keeps them
+		restored.AssertRows(t, "blog.dbo.authors", []map[string]interface{}{
+			{"id": 1, "first_name": "Terrill"},
+			{"id": 2, "first_name": "Jamar"},
+		})
+
+		// a restored database doesn't inherit delayed durability from model
+		forced, err := restored.Count("sys.databases", "name = 'blog' AND delayed_durability_desc = 'FORCED'")
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 1, forced)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SqlServer_TmpfsSize(t *testing.T) {
+	container, _ := easycontainers.NewSQLServer("Test_SqlServer_TmpfsSize")
+
+	container.FastMode = true
+	container.TmpfsSize = "1g"
+
+	err := container.Container(func() error {
+		t.Error("the callback shouldn't run when TmpfsSize is set")
+
+		return nil
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "TmpfsSize has to be empty")
+	}
+}
+
+func Test_SqlServer_Golden(t *testing.T) {
+	container, _ := easycontainers.NewSQLServer("Test_SqlServer_Golden")
+
+	container.Path = "/src/github.com/tsmith-rv/easycontainers/test/sqlserver-test.sql"
+
+	err := container.Container(func() error {
+		_, err := container.Exec("DELETE FROM blog.authors WHERE id = 5")
+		if err != nil {
+			return err
+		}
+
+		container.AssertGolden(t, "testdata/authors.golden", "blog.authors")
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
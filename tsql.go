@@ -0,0 +1,183 @@
+package easycontainers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// goSeparator matches a line with the GO batch separator, optionally followed by the number
+// of times to run the batch, the same way sqlcmd and SSMS read it
+var goSeparator = regexp.MustCompile(`(?i)^\s*GO(?:\s+(\d+))?\s*(?:--.*)?$`)
+
+// sqlBatch is a batch of T-SQL from a script split on GO lines.
+type sqlBatch struct {
+	sql string
+
+	// line is the line of the script the batch starts on, counting from 1
+	line int
+
+	// count is the number of times the batch runs
+	count int
+}
+
+// splitBatches splits a T-SQL script into the batches separated by GO lines. GO lines inside
+// strings, quoted identifiers and block comments are part of the batch. Batches with nothing
+// but whitespace in them are left out.
+func splitBatches(script string) []sqlBatch {
+	var (
+		batches []sqlBatch
+		lines   []string
+		start   = 1
+		scanner tsqlScanner
+	)
+
+	add := func(count int) {
+		batch := strings.Join(lines, "\n")
+
+		if strings.TrimSpace(batch) != "" {
+			batches = append(batches, sqlBatch{
+				sql:   batch,
+				line:  start,
+				count: count,
+			})
+		}
+	}
+
+	for i, line := range strings.Split(script, "\n") {
+		if scanner.normal() {
+			if match := goSeparator.FindStringSubmatch(strings.TrimRight(line, "\r")); match != nil {
+				count := 1
+				if match[1] != "" {
+					count, _ = strconv.Atoi(match[1])
+				}
+
+				add(count)
+
+				lines = nil
+				start = i + 2
+
+				continue
+			}
+		}
+
+		scanner.scan(line)
+		lines = append(lines, line)
+	}
+
+	add(1)
+
+	return batches
+}
+
+// tsqlBatches returns the sql of the batches in the script, each repeated as many times
+// as its GO line says.
+func tsqlBatches(script string) []string {
+	var batches []string
+
+	for _, b := range splitBatches(script) {
+		for i := 0; i < b.count; i++ {
+			batches = append(batches, b.sql)
+		}
+	}
+
+	return batches
+}
+
+// tsqlScanner keeps track of whether the end of the T-SQL scanned so far is inside a
+// string, a quoted identifier or a block comment.
+type tsqlScanner struct {
+	// quote is the character that closes the string or identifier the scanner is in
+	quote byte
+
+	// comments is how deeply nested in block comments the scanner is, since T-SQL
+	// allows nesting them
+	comments int
+}
+
+func (s *tsqlScanner) normal() bool {
+	return s.quote == 0 && s.comments == 0
+}
+
+// scan scans a line. Line comments end with the line, so they don't need to be tracked.
+func (s *tsqlScanner) scan(line string) {
+	// all of the characters we look for are ascii, so walking the bytes is safe
+	for i := 0; i < len(line); i++ {
+		var (
+			c    = line[i]
+			next byte
+		)
+
+		if i+1 < len(line) {
+			next = line[i+1]
+		}
+
+		switch {
+		case s.comments > 0:
+			if c == '*' && next == '/' {
+				s.comments--
+				i++
+			} else if c == '/' && next == '*' {
+				s.comments++
+				i++
+			}
+		case s.quote != 0:
+			// an escaped quote closes and reopens the string, which comes out the same
+			if c == s.quote {
+				s.quote = 0
+			}
+		case c == '\'' || c == '"':
+			s.quote = c
+		case c == '[':
+			s.quote = ']'
+		case c == '-' && next == '-':
+			return
+		case c == '/' && next == '*':
+			s.comments++
+			i++
+		}
+	}
+}
+
+// runBatches runs the batches of the script one after the other on the same connection,
+// so a USE in one batch carries over to the next ones, like it does in sqlcmd. The first
+// batch that fails stops the script, and is returned as a StartupSQLError for source.
+func runBatches(ctx context.Context, conn *sql.Conn, source, script string) error {
+	for _, b := range splitBatches(script) {
+		for i := 0; i < b.count; i++ {
+			_, err := conn.ExecContext(ctx, b.sql)
+			if err != nil {
+				return batchError(source, b, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// batchError describes the batch that failed, with the server's message in the format
+// sqlcmd prints it and the line it refers to counted from the start of the script rather
+// than the start of the batch.
+func batchError(source string, b sqlBatch, err error) error {
+	sqlErr := &StartupSQLError{
+		Source:    source,
+		Line:      b.line,
+		Statement: strings.TrimSpace(b.sql),
+		Message:   err.Error(),
+	}
+
+	if e, ok := err.(mssql.Error); ok {
+		if e.LineNo > 0 {
+			sqlErr.Line = b.line + int(e.LineNo) - 1
+		}
+
+		sqlErr.Message = fmt.Sprintf("Msg %d, Level %d, State %d, Line %d: %s", e.Number, e.Class, e.State, e.LineNo, e.Message)
+	}
+
+	return sqlErr
+}